@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/TheCreeper/OpenPushOver/notification"
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+// Name of the notification action that acknowledges a message
+const (
+	AckAction = "acknowledge"
+)
+
+// Some errors
+var (
+	ErrNoReceipt = errors.New("Message does not need to be acknowledged")
+)
+
+// Show an emergency notification and acknowledge the message when its
+// Acknowledge action is clicked
+func (s *Session) AckOnAction(n *notification.Message, msg pushover.PullMessage) {
+
+	action, err := n.PushAction()
+	if err != nil {
+
+		log.Warn(err)
+		return
+	}
+	if action != AckAction {
+
+		return
+	}
+
+	err = s.client.Acknowledge(msg.Receipt)
+	if err != nil {
+
+		s.check(err)
+		log.Warnf("Acknowledge: %s", err)
+		return
+	}
+	log.Infof("[%d]: Acknowledged", msg.ID)
+
+	history := s.History()
+	history.SetAcked(msg.ID)
+	err = history.Flush()
+	if err != nil {
+
+		log.Warn(err)
+	}
+}
+
+// Acknowledge a message from the history of whichever account received it
+func (cfg *ClientConfig) AckMessage(id int) (err error) {
+
+	for i := range cfg.Accounts {
+
+		acn := &cfg.Accounts[i]
+		if len(acn.DeviceUUID) < 1 {
+
+			continue
+		}
+
+		history, err := LoadHistory(HistoryFile(cfg.Globals.CacheDir, acn.DeviceUUID))
+		if err != nil {
+
+			return err
+		}
+
+		msg, err := history.Find(id)
+		if err != nil {
+
+			continue
+		}
+		if len(msg.Receipt) < 1 {
+
+			return ErrNoReceipt
+		}
+
+		client := cfg.NewClient(acn)
+		ctx := context.Background()
+		err = cfg.Authenticate(ctx, client, acn)
+		if err != nil {
+
+			return err
+		}
+
+		err = cfg.WithLogin(ctx, client, acn, func() error {
+
+			return client.Acknowledge(msg.Receipt)
+		})
+		if err != nil {
+
+			return err
+		}
+
+		history.SetAcked(id)
+		return history.Flush()
+	}
+
+	return ErrMsgNotFound
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker defaults
+const (
+	BreakerThreshold = 5               // Consecutive failures before opening
+	BreakerCooldown  = 5 * time.Minute // Time spent open before a trial call
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Calls go through
+	BreakerOpen                         // Calls are skipped until the cooldown ends
+	BreakerHalfOpen                     // A trial call decides whether to close again
+)
+
+func (s BreakerState) String() string {
+
+	switch s {
+
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+// Stops an account from hammering the API while it is failing. Retries of
+// single calls are left to the pushover.RetryPolicy of the client.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+func NewBreaker() *Breaker {
+
+	return &Breaker{
+
+		Threshold: BreakerThreshold,
+		Cooldown:  BreakerCooldown,
+	}
+}
+
+// Report whether a call may be made. Once the cooldown has passed the
+// breaker becomes half-open and lets a trial call through.
+func (b *Breaker) Allow() bool {
+
+	return b.Wait() == 0
+}
+
+// Return how long until a call may be made
+func (b *Breaker) Wait() time.Duration {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerOpen {
+
+		return 0
+	}
+
+	wait := b.Cooldown - time.Since(b.openedAt)
+	if wait > 0 {
+
+		return wait
+	}
+
+	b.state = BreakerHalfOpen
+	return 0
+}
+
+func (b *Breaker) Success() {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+func (b *Breaker) Failure() {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *Breaker) State() BreakerState {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
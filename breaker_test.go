@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+
+	b := NewBreaker()
+	b.Threshold = 2
+	b.Cooldown = 50 * time.Millisecond
+
+	b.Failure()
+	if b.State() != BreakerClosed || !b.Allow() {
+
+		t.Fatalf("open after one failure, state %v", b.State())
+	}
+
+	b.Failure()
+	if b.State() != BreakerOpen || b.Allow() || b.Wait() <= 0 {
+
+		t.Fatalf("not open after %d failures, state %v", b.Threshold, b.State())
+	}
+
+	// After the cooldown a single trial call is let through
+	time.Sleep(b.Cooldown)
+	if !b.Allow() || b.State() != BreakerHalfOpen {
+
+		t.Fatalf("not half-open after the cooldown, state %v", b.State())
+	}
+
+	// A failed trial opens it again straight away
+	b.Failure()
+	if b.State() != BreakerOpen || b.Allow() {
+
+		t.Fatalf("not open after a failed trial, state %v", b.State())
+	}
+
+	time.Sleep(b.Cooldown)
+	if !b.Allow() {
+
+		t.Fatal("no trial call after the second cooldown")
+	}
+
+	b.Success()
+	if b.State() != BreakerClosed || !b.Allow() {
+
+		t.Fatalf("not closed after a successful trial, state %v", b.State())
+	}
+
+	// Failures are counted again from zero
+	b.Failure()
+	if b.State() != BreakerClosed {
+
+		t.Fatalf("open after one failure following a success, state %v", b.State())
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"os"
+	"net/http"
+	"sync"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
 )
 
 const (
@@ -20,7 +22,8 @@ var (
 // Some errors
 var (
 	ErrNoDevName    = errors.New("No device name specified")
-	ErrCheckSeconds = fmt.Errorf("No time specified for checkseconds or less than %s", MinCheckSeconds)
+	ErrCheckSeconds = fmt.Errorf("No time specified for checkseconds or less than %d", MinCheckSeconds)
+	ErrReregister   = fmt.Errorf("Reregister must be %q or %q", ReregisterAuto, ReregisterNever)
 )
 
 type ClientConfig struct {
@@ -36,21 +39,41 @@ type ClientConfig struct {
 	}
 
 	Accounts []Account
+
+	mu          sync.Mutex
+	httpClients map[string]*http.Client // Shared by the accounts using each proxy
+	prefetch    sync.Once
+	state       *State                 // Secrets saved outside the config
+	setup       func(*pushover.Client) // Applied to every new client, tests use it to point them at a fake server
 }
 
 type Globals struct {
 	CacheDir     string
 	DeviceName   string
 	CheckSeconds int
+	Polling      bool   // Poll every CheckSeconds instead of listening on the stream
+	AppToken     string // Optional, used to list custom sounds for prefetching
 }
 
 type Account struct {
 	DeviceUUID string
 
 	Username string
-	Password string
+	Password string // Only needed until a secret has been saved, or when it expires
+
+	Key  string
+	Keys []pushover.SharedKey // Keys with ids and validity windows, for rotating Key
+
+	KeyGraceSeconds int // Time a key still opens messages outside its validity window, an hour when unset
+
+	AppKeys []pushover.AppKey // Keys of applications that don't use Key and Keys
 
-	Key string
+	PrivateKey string   // Opens messages boxed for this account, see the keys command
+	SenderKeys []string // Public keys allowed to send boxed messages, any when empty
+
+	TOTPSecret string // Base32 secret of the two-factor authenticator, if enabled
+
+	Reregister string // What to do when the device is deleted, "auto" (default) or "never"
 
 	Proxy         string
 	proxyType     string
@@ -60,31 +83,37 @@ type Account struct {
 	proxyTimeout  int
 }
 
+// Save the config. It holds passwords and keys, so only its owner can
+// read it.
 func (cfg *ClientConfig) Flush(f string) (err error) {
 
-	file, err := os.OpenFile(f, os.O_RDWR|os.O_CREATE, 0666)
+	b, err := json.MarshalIndent(cfg, "", "	")
 	if err != nil {
 
 		return
 	}
-	defer file.Close()
 
-	b, err := json.MarshalIndent(cfg, "", "	")
-	if err != nil {
+	return WritePrivateFile(f, b)
+}
 
-		return
-	}
+// Return the device of an account. Sessions may replace it at any time, so
+// it is read under the same lock.
+func (cfg *ClientConfig) DeviceUUID(acn *Account) string {
 
-	buf := bufio.NewWriter(file)
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
 
-	_, err = buf.Write(b)
-	if err != nil {
+	return acn.DeviceUUID
+}
 
-		return
-	}
-	defer buf.Flush()
+// Set the device of an account and save it to the config
+func (cfg *ClientConfig) SetDeviceUUID(acn *Account, uuid string) (err error) {
 
-	return
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	acn.DeviceUUID = uuid
+	return cfg.Flush(ConfigFile)
 }
 
 func (cfg *ClientConfig) validate() (err error) {
@@ -101,6 +130,25 @@ func (cfg *ClientConfig) validate() (err error) {
 
 	for i, v := range cfg.Accounts {
 
+		err = pushover.VerifyKeyring(v.Keys)
+		if err != nil {
+
+			return fmt.Errorf("%s: %s", v.Username, err)
+		}
+
+		err = pushover.VerifyAppKeys(v.AppKeys)
+		if err != nil {
+
+			return fmt.Errorf("%s: %s", v.Username, err)
+		}
+
+		switch v.Reregister {
+
+		case "", ReregisterAuto, ReregisterNever:
+		default:
+			return ErrReregister
+		}
+
 		if len(v.Proxy) < 1 {
 
 			continue
@@ -142,5 +190,11 @@ func GetCFG(f string) (cfg *ClientConfig, err error) {
 		return
 	}
 
+	cfg.state, err = LoadState(StateFile(f))
+	if err != nil {
+
+		return
+	}
+
 	return
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+// Some errors
+var (
+	ErrDeviceNotFound = errors.New("Device not found on any account")
+	ErrDevicesUsage   = errors.New("devices: expected list, rename <id> <name>, disable <id> or delete <id>")
+)
+
+// Run the devices command. Without arguments the devices of every account
+// are listed, with the ones used by this config marked. Other commands act
+// on whichever account owns the device.
+func (cfg *ClientConfig) DevicesCommand(args []string) (err error) {
+
+	if len(args) < 1 || args[0] == "list" {
+
+		return cfg.ListDevices()
+	}
+
+	switch {
+
+	case args[0] == "rename" && len(args) == 3:
+		return cfg.withDevice(args[1], func(client *pushover.Client, acn *Account) error {
+
+			_, err := client.RenameDevice(args[1], args[2])
+			return err
+		})
+
+	case args[0] == "disable" && len(args) == 2:
+		return cfg.withDevice(args[1], func(client *pushover.Client, acn *Account) error {
+
+			_, err := client.DisableDevice(args[1])
+			return err
+		})
+
+	case args[0] == "delete" && len(args) == 2:
+		return cfg.withDevice(args[1], func(client *pushover.Client, acn *Account) error {
+
+			_, err := client.DeleteDevice(args[1])
+			if err != nil {
+
+				return err
+			}
+
+			// Register a new device on the next start
+			if acn.DeviceUUID == args[1] {
+
+				return cfg.SetDeviceUUID(acn, "")
+			}
+			return nil
+		})
+	}
+
+	return ErrDevicesUsage
+}
+
+// Print the devices of every account
+func (cfg *ClientConfig) ListDevices() (err error) {
+
+	for i := range cfg.Accounts {
+
+		acn := &cfg.Accounts[i]
+
+		ctx := context.Background()
+		client := cfg.NewClient(acn)
+		err = cfg.Authenticate(ctx, client, acn)
+		if err != nil {
+
+			return
+		}
+
+		var devices []pushover.Device
+		err = cfg.WithLogin(ctx, client, acn, func() (err error) {
+
+			devices, err = client.ListDevices()
+			return
+		})
+		if err != nil {
+
+			return
+		}
+
+		fmt.Printf("%s:\n", acn.Username)
+		for _, d := range devices {
+
+			var flags string
+			if d.ID == acn.DeviceUUID {
+
+				flags += " (this device)"
+			}
+			if d.Disabled {
+
+				flags += " (disabled)"
+			}
+			fmt.Printf("  %s\t%s\t%s%s\n", d.ID, d.Name, d.OS, flags)
+		}
+	}
+
+	return
+}
+
+// Login to each account in turn and call fn on the first one that owns
+// the device
+func (cfg *ClientConfig) withDevice(id string, fn func(*pushover.Client, *Account) error) (err error) {
+
+	for i := range cfg.Accounts {
+
+		acn := &cfg.Accounts[i]
+
+		ctx := context.Background()
+		client := cfg.NewClient(acn)
+		err = cfg.Authenticate(ctx, client, acn)
+		if err != nil {
+
+			return
+		}
+
+		var devices []pushover.Device
+		err = cfg.WithLogin(ctx, client, acn, func() (err error) {
+
+			devices, err = client.ListDevices()
+			return
+		})
+		if err != nil {
+
+			return
+		}
+
+		for _, d := range devices {
+
+			if d.ID == id {
+
+				return fn(client, acn)
+			}
+		}
+	}
+
+	return ErrDeviceNotFound
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+func TestDevicesCommand(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	other := "otherdevice0123456789"
+	srv.AddDevice(pushover.Device{ID: srv.DeviceID, Name: pushovertest.Device, OS: "O"})
+	srv.AddDevice(pushover.Device{ID: other, Name: "laptop", OS: "O"})
+
+	cfg := testConfig(t, srv)
+	cfg.Accounts[0].DeviceUUID = srv.DeviceID
+
+	err := cfg.DevicesCommand([]string{"list"})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	err = cfg.DevicesCommand([]string{"rename", other, "old-laptop"})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if d, _ := srv.Device(other); d.Name != "old-laptop" {
+
+		t.Fatalf("device is named %q", d.Name)
+	}
+
+	err = cfg.DevicesCommand([]string{"disable", other})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if d, _ := srv.Device(other); !d.Disabled {
+
+		t.Fatal("device was not disabled")
+	}
+
+	// Deleting the device of this config registers a new one on next start
+	err = cfg.DevicesCommand([]string{"delete", srv.DeviceID})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if _, ok := srv.Device(srv.DeviceID); ok {
+
+		t.Fatal("device was not deleted")
+	}
+	if len(cfg.Accounts[0].DeviceUUID) > 0 {
+
+		t.Fatalf("device id is still %q", cfg.Accounts[0].DeviceUUID)
+	}
+
+	saved, err := GetCFG(ConfigFile)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if len(saved.Accounts[0].DeviceUUID) > 0 {
+
+		t.Fatalf("saved device id is still %q", saved.Accounts[0].DeviceUUID)
+	}
+
+	// The other device is kept
+	if _, ok := srv.Device(other); !ok {
+
+		t.Fatal("other device was deleted")
+	}
+
+	err = cfg.DevicesCommand([]string{"delete", "nosuchdevice"})
+	if err != ErrDeviceNotFound {
+
+		t.Fatalf("got %v, want ErrDeviceNotFound", err)
+	}
+
+	err = cfg.DevicesCommand([]string{"rename", other})
+	if err != ErrDevicesUsage {
+
+		t.Fatalf("got %v, want ErrDevicesUsage", err)
+	}
+}
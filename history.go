@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+// Maximum number of messages kept in the history of an account
+const (
+	MaxHistory = 100
+)
+
+// Some errors
+var (
+	ErrMsgNotFound = errors.New("Message not found in history")
+)
+
+// Messages received by an account, kept in the cache directory
+type History struct {
+	mu   sync.Mutex
+	file string
+
+	Messages []pushover.PullMessage
+}
+
+func HistoryFile(cacheDir, deviceUUID string) string {
+
+	return filepath.Join(cacheDir, "history-"+deviceUUID+".json")
+}
+
+// Load the history from a file. A missing file gives an empty history.
+func LoadHistory(f string) (h *History, err error) {
+
+	h = &History{file: f}
+
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+
+		return h, nil
+	}
+	if err != nil {
+
+		return
+	}
+
+	err = json.Unmarshal(b, h)
+	if err != nil {
+
+		return
+	}
+
+	return
+}
+
+// Add messages, dropping the oldest ones past MaxHistory along with their
+// attachments
+func (h *History) Add(msgs ...pushover.PullMessage) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.Messages = append(h.Messages, msgs...)
+	if len(h.Messages) > MaxHistory {
+
+		for _, v := range h.Messages[:len(h.Messages)-MaxHistory] {
+
+			if len(v.Attachment) > 0 {
+
+				os.Remove(h.AttachmentFile(v))
+			}
+		}
+		h.Messages = h.Messages[len(h.Messages)-MaxHistory:]
+	}
+}
+
+// Return where the attachment of a message is kept, next to the history file
+func (h *History) AttachmentFile(msg pushover.PullMessage) string {
+
+	ext := path.Ext(msg.Attachment)
+	if u, err := url.Parse(msg.Attachment); err == nil {
+
+		ext = path.Ext(u.Path)
+	}
+
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(h.file, ".json"), msg.ID, ext)
+}
+
+func (h *History) Find(id int) (msg pushover.PullMessage, err error) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, v := range h.Messages {
+
+		if v.ID == id && !v.Expired(time.Now()) {
+
+			return v, nil
+		}
+	}
+
+	return msg, ErrMsgNotFound
+}
+
+// Mark a message as acknowledged
+func (h *History) SetAcked(id int) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.Messages {
+
+		if h.Messages[i].ID == id {
+
+			h.Messages[i].Acked = 1
+		}
+	}
+}
+
+// Write the history to its file, first dropping expired messages and their
+// attachments
+func (h *History) Flush() (err error) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	kept := h.Messages[:0]
+	for _, v := range h.Messages {
+
+		if !v.Expired(now) {
+
+			kept = append(kept, v)
+		} else if len(v.Attachment) > 0 {
+
+			os.Remove(h.AttachmentFile(v))
+		}
+	}
+	h.Messages = kept
+
+	b, err := json.MarshalIndent(h, "", "	")
+	if err != nil {
+
+		return
+	}
+
+	return WritePrivateFile(h.file, b)
+}
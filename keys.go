@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+// Print the public key of every account for senders to box messages with.
+// Accounts without a private key are given one and the config is saved.
+func (cfg *ClientConfig) PrintKeys() (err error) {
+
+	var created bool
+	for i := range cfg.Accounts {
+
+		acn := &cfg.Accounts[i]
+		if len(acn.PrivateKey) < 1 {
+
+			_, acn.PrivateKey, err = pushover.GenerateKeyPair()
+			if err != nil {
+
+				return
+			}
+			created = true
+		}
+
+		pub, err := pushover.PublicKey(acn.PrivateKey)
+		if err != nil {
+
+			return fmt.Errorf("%s: %s", acn.Username, err)
+		}
+		fmt.Printf("%s\t%s\n", acn.Username, pub)
+	}
+
+	if created {
+
+		return cfg.Flush(ConfigFile)
+	}
+
+	return
+}
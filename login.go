@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+// Some errors
+var (
+	ErrNoTwoFactor = errors.New("Account needs a two-factor code, set TOTPSecret or login from a terminal")
+)
+
+// Only one account asks for a code at a time
+var promptMu sync.Mutex
+
+// Resume the session of the account with its saved secret. The password
+// is only used when no secret has been saved yet.
+func (cfg *ClientConfig) Authenticate(ctx context.Context, client *pushover.Client, acn *Account) (err error) {
+
+	if secret := cfg.state.Secret(acn.Username); len(secret) > 0 {
+
+		client.SetSecret(secret)
+		return
+	}
+
+	return cfg.Login(ctx, client, acn)
+}
+
+// Call fn, logging in again with the password and retrying once when the
+// API reports that the saved secret is no longer valid
+func (cfg *ClientConfig) WithLogin(ctx context.Context, client *pushover.Client, acn *Account, fn func() error) (err error) {
+
+	err = fn()
+	if !errors.Is(err, pushover.ErrSecretExpired) {
+
+		return
+	}
+
+	log.Warnf("%s: Secret expired, logging in again", acn.Username)
+	err = cfg.Login(ctx, client, acn)
+	if err != nil {
+
+		return
+	}
+
+	return fn()
+}
+
+// Login the account with its password and save the new secret, answering
+// a two-factor challenge with a code from TOTPSecret or, failing that, one
+// typed on the terminal
+func (cfg *ClientConfig) Login(ctx context.Context, client *pushover.Client, acn *Account) (err error) {
+
+	client.TwoFactorCode = ""
+	_, err = client.LoginDeviceContext(ctx)
+	if errors.Is(err, pushover.ErrTwoFactor) {
+
+		err = cfg.loginTwoFactor(ctx, client, acn)
+	}
+	if err != nil {
+
+		return
+	}
+
+	cfg.state.SetSecret(acn.Username, client.Secret())
+	return cfg.state.Flush()
+}
+
+func (cfg *ClientConfig) loginTwoFactor(ctx context.Context, client *pushover.Client, acn *Account) (err error) {
+
+	defer func() { client.TwoFactorCode = "" }()
+
+	if len(acn.TOTPSecret) < 1 {
+
+		client.TwoFactorCode, err = promptCode(acn.Username)
+		if err != nil {
+
+			return
+		}
+
+		_, err = client.LoginDeviceContext(ctx)
+		return
+	}
+
+	// A code made at the end of its window may reach the server after the
+	// window has passed, so the code of the next window is tried once too
+	now := time.Now()
+	for _, t := range []time.Time{now, now.Add(TOTPPeriod * time.Second)} {
+
+		client.TwoFactorCode, err = TOTP(acn.TOTPSecret, t)
+		if err != nil {
+
+			return
+		}
+
+		_, err = client.LoginDeviceContext(ctx)
+		if !errors.Is(err, pushover.ErrTwoFactor) {
+
+			return
+		}
+	}
+
+	return
+}
+
+// Ask for a two-factor code on the terminal
+func promptCode(username string) (code string, err error) {
+
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+
+		return
+	}
+	if fi.Mode()&os.ModeCharDevice == 0 {
+
+		return "", ErrNoTwoFactor
+	}
+
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "Two-factor code for %s: ", username)
+	code, err = bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+
+		return
+	}
+
+	return strings.TrimSpace(code), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The server is a window ahead, so the first code gets a 412 and the code
+// of the next window is accepted
+func TestLoginTwoFactor(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	code, err := TOTP(testTOTPSecret, time.Now().Add(TOTPPeriod*time.Second))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	srv.TwoFA = code
+
+	cfg := testConfig(t, srv)
+	acn := &cfg.Accounts[0]
+	acn.TOTPSecret = testTOTPSecret
+
+	client := cfg.NewClient(acn)
+	err = cfg.Login(context.Background(), client, acn)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if client.Secret() != srv.Secret || len(client.TwoFactorCode) > 0 {
+
+		t.Fatalf("secret %q, code %q left after login", client.Secret(), client.TwoFactorCode)
+	}
+
+	// The secret is saved so the next start needs no code
+	st, err := LoadState(StateFile(ConfigFile))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if st.Secret(acn.Username) != srv.Secret {
+
+		t.Fatalf("saved secret is %q", st.Secret(acn.Username))
+	}
+}
+
+func TestLoginTwoFactorRejected(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	srv.TwoFA = "never"
+
+	cfg := testConfig(t, srv)
+	acn := &cfg.Accounts[0]
+	acn.TOTPSecret = testTOTPSecret
+
+	client := cfg.NewClient(acn)
+	err := cfg.Login(context.Background(), client, acn)
+	if !errors.Is(err, pushover.ErrTwoFactor) {
+
+		t.Fatalf("got %v, want ErrTwoFactor", err)
+	}
+	if len(cfg.state.Secret(acn.Username)) > 0 {
+
+		t.Fatal("secret saved after a failed login")
+	}
+}
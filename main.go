@@ -7,10 +7,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -31,18 +36,25 @@ var PushoverToNotifyPriority = map[int]string{
 	pushover.HighestPriority: notification.CriticalPriority,
 }
 
-func (cfg *ClientConfig) LaunchClient(wg *sync.WaitGroup, acn *Account) {
+// Create a client for the account with its proxy applied
+func (cfg *ClientConfig) NewClient(acn *Account) *pushover.Client {
 
-	// Generate a UUID and save it to the config
 	client := &pushover.Client{
 
 		UserName:     acn.Username,
 		UserPassword: acn.Password,
 
-		Key: acn.Key,
+		Key:        acn.Key,
+		Keyring:    acn.Keys,
+		AppKeys:    acn.AppKeys,
+		KeyGrace:   time.Duration(acn.KeyGraceSeconds) * time.Second,
+		PrivateKey: acn.PrivateKey,
+		SenderKeys: acn.SenderKeys,
 
 		DeviceName: cfg.Globals.DeviceName,
 		DeviceUUID: acn.DeviceUUID,
+
+		AppToken: cfg.Globals.AppToken,
 	}
 
 	// Specify some other options
@@ -58,165 +70,282 @@ func (cfg *ClientConfig) LaunchClient(wg *sync.WaitGroup, acn *Account) {
 		}
 		client.Dial = conn.HandleConnection
 	}
+	client.HTTPClient = cfg.HTTPClient(acn.Proxy, client.Dial)
 
-	err := client.LoginDevice()
-	if err != nil {
+	if cfg.setup != nil {
 
-		log.Errorf("LoginDevice: %s", err)
-		return
+		cfg.setup(client)
 	}
+
+	return client
+}
+
+func (cfg *ClientConfig) LaunchClient(ctx context.Context, wg *sync.WaitGroup, acn *Account) {
+
 	defer wg.Done()
 
-	if len(acn.DeviceUUID) < 1 {
+	client := cfg.NewClient(acn)
+	policy := pushover.DefaultRetryPolicy
+	client.Retry = &policy
 
-		err = client.RegisterDevice()
-		if err != nil {
+	NewSession(cfg, acn, client).Run(ctx)
+}
 
-			log.Errorf("RegisterDevice: %s", err)
-			return
-		}
-		acn.DeviceUUID = client.DeviceUUID
+// Poll for new messages every CheckSeconds
+func (s *Session) Poll(ctx context.Context) {
 
-		err = cfg.Flush(ConfigFile)
-		if err != nil {
+	for sleep(ctx, time.Duration(s.cfg.Globals.CheckSeconds)*time.Second) {
 
-			log.Errorf("Flush: %s", err)
-			return
-		}
+		s.ProcessMessages(ctx)
 	}
+}
 
-	for {
+// Sleep for d. Returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
 
-		time.Sleep(time.Duration(cfg.Globals.CheckSeconds) * time.Second)
+	t := time.NewTimer(d)
+	defer t.Stop()
 
-		fetched, err := client.FetchMessages()
-		if err != nil {
+	select {
 
-			log.Warn(err)
-			continue
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-		}
-		if fetched > 0 {
+// Fetch new messages, trigger the desktop notifications, record them in the
+// history and mark them read. Skipped while the circuit breaker is open.
+// Authentication failures move the session to login or register again.
+func (s *Session) ProcessMessages(ctx context.Context) {
 
-			log.Infof("Fetched %d Messages", fetched)
+	cfg, client, history := s.cfg, s.client, s.History()
 
-			for _, v := range client.MessagesResponse.Messages {
+	if !s.breaker.Allow() {
 
-				// Check if quiet hours is enabled
-				if (client.MessagesResponse.User.QuietHours) && (v.Priority == pushover.NormalPriority) {
+		return
+	}
 
-					v.Priority = pushover.LowPriority
-				}
+	resp, err := client.FetchMessagesContext(ctx)
+	if s.check(err) {
 
-				var snd string
-				// Check if sound file exists
-				if len(v.Sound) > 1 {
+		return
+	}
+	s.record(err)
+	if err != nil {
 
-					f, err := filepath.Abs(filepath.Join(cfg.Globals.CacheDir, v.Sound+".wav"))
-					if err != nil {
+		log.Warn(err)
+		return
+	}
+	fetched := len(resp.Messages)
+	if fetched < 1 {
 
-						log.Error(err)
-						return
-					}
-					snd = f
+		return
+	}
 
-					exists, err := FileExists(snd)
-					if err != nil {
+	log.Infof("Fetched %d Messages", fetched)
 
-						log.Warn(err)
-					}
-					if !exists {
+	for _, v := range resp.Messages {
 
-						b, err := client.FetchSound(v.Sound)
-						if err != nil {
+		// Skip messages whose TTL ran out while we were offline
+		if v.Expired(time.Now()) {
 
-							log.Warn(err)
-						}
+			log.Infof("[%d]: Expired", v.ID)
+			continue
+		}
 
-						err = WriteToFile(snd, b)
-						if err != nil {
+		if v.Undecryptable != nil {
 
-							log.Warn(err)
-						}
-					}
-				}
+			log.Warnf("[%d]: %s", v.ID, v.Undecryptable)
+		}
 
-				var img string
-				// Check if image file exists
-				if len(v.Icon) > 1 {
+		// Check if quiet hours is enabled
+		if (resp.User.QuietHours) && (v.Priority == pushover.NormalPriority) {
 
-					f, err := filepath.Abs(filepath.Join(cfg.Globals.CacheDir, fmt.Sprintf("%s.png", v.Icon)))
-					if err != nil {
+			v.Priority = pushover.LowPriority
+		}
 
-						log.Error(err)
-						return
-					}
-					img = f
+		var snd string
+		// Check if sound file exists
+		if len(v.Sound) > 1 {
 
-					exists, err := FileExists(img)
-					if err != nil {
+			snd, err = SoundFile(cfg.Globals.CacheDir, v.Sound)
+			if err != nil {
 
-						log.Warn(err)
-					}
-					if !exists {
+				log.Warn(err)
+			}
 
-						b, err := client.FetchImage(v.Icon)
-						if err != nil {
+			exists, err := CachedFile(snd)
+			if err != nil {
 
-							log.Warn(err)
-						}
+				log.Warn(err)
+			}
+			if len(snd) > 0 && !exists {
 
-						err = WriteToFile(img, b)
-						if err != nil {
+				b, err := client.FetchSoundContext(ctx, v.Sound)
+				if err != nil {
 
-							log.Warn(err)
-						}
-					}
+					log.Warn(err)
+					snd = ""
+				} else if err = WriteToFile(snd, b); err != nil {
+
+					log.Warn(err)
+					snd = ""
 				}
+			}
+		}
 
-				v.Title = fmt.Sprintf("%s (%s)", v.Title, time.Unix(v.Date, 0).Format("2006-01-02 15:04:05"))
+		var img string
+		// Check if image file exists
+		if len(v.Icon) > 1 {
 
-				// trigger the desktop notifications
-				n := &notification.Message{
+			img, err = filepath.Abs(filepath.Join(cfg.Globals.CacheDir, fmt.Sprintf("%s.png", v.Icon)))
+			if err != nil {
 
-					Title:    v.Title,
-					Body:     v.Message,
-					Urgency:  PushoverToNotifyPriority[v.Priority],
-					Icon:     img,
-					Category: "im.received",
-					Sound:    snd,
-				}
-				err = n.Push()
+				log.Warn(err)
+				img = ""
+			}
+
+			exists, err := CachedFile(img)
+			if err != nil {
+
+				log.Warn(err)
+			}
+			if len(img) > 0 && !exists {
+
+				b, err := client.FetchImageContext(ctx, v.Icon)
 				if err != nil {
 
 					log.Warn(err)
+					img = ""
+				} else if err = WriteToFile(img, b); err != nil {
+
+					log.Warn(err)
+					img = ""
 				}
+			}
+		}
+
+		var attachment string
+		// Download the attached image into the cache next to the history
+		if len(v.Attachment) > 0 {
+
+			attachment, err = filepath.Abs(history.AttachmentFile(v))
+			if err != nil {
 
-				// Print the notification to terminal
-				log.Infof("[%d]: %s: %s", v.ID, v.Title, v.Message)
+				log.Warn(err)
+				attachment = ""
 			}
 
-			err = client.MarkReadHighest()
+			exists, err := CachedFile(attachment)
+			if err != nil {
+
+				log.Warn(err)
+			}
+			if len(attachment) > 0 && !exists {
+
+				b, err := client.FetchAttachmentContext(ctx, v.Attachment)
+				if err != nil {
+
+					log.Warn(err)
+					attachment = ""
+				} else if err = WritePrivateFile(attachment, b); err != nil {
+
+					log.Warn(err)
+					attachment = ""
+				}
+			}
+		}
+
+		v.Title = fmt.Sprintf("%s (%s)", v.Title, time.Unix(v.Date, 0).Format("2006-01-02 15:04:05"))
+
+		// trigger the desktop notifications
+		n := &notification.Message{
+
+			Title:    v.Title,
+			Body:     FormatBody(v),
+			Urgency:  PushoverToNotifyPriority[v.Priority],
+			Icon:     img,
+			Image:    attachment,
+			Category: "im.received",
+			Sound:    snd,
+		}
+
+		// Emergency messages keep alerting every device until acknowledged
+		if (v.Priority == pushover.HighestPriority) && (len(v.Receipt) > 0) && (v.Acked == 0) {
+
+			n.Actions = []notification.Action{{Name: AckAction, Label: "Acknowledge"}}
+			go s.AckOnAction(n, v)
+		} else if expires := v.Expires(); !expires.IsZero() {
+
+			s.pushUntil(n, expires)
+		} else {
+
+			err = n.Push()
 			if err != nil {
 
 				log.Warn(err)
 			}
 		}
+
+		// Print the notification to terminal
+		log.Infof("[%d]: %s: %s", v.ID, v.Title, v.Message)
 	}
 
-	wg.Done()
+	history.Add(resp.Messages...)
+	err = history.Flush()
+	if err != nil {
+
+		log.Warn(err)
+	}
+
+	_, err = client.MarkReadContext(ctx, resp.Highest())
+	if err != nil && !s.check(err) {
+
+		log.Warn(err)
+	}
 }
 
 func init() {
 
 	flag.StringVar(&ConfigFile, "config", "./config.json", "The configuration file location")
-	flag.Parse()
+	flag.Usage = func() {
+
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [command]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  ack <id>\t\t\tAcknowledge an emergency message from the history\n")
+		fmt.Fprintf(os.Stderr, "  devices [list]\t\t\tList the devices of every account\n")
+		fmt.Fprintf(os.Stderr, "  devices rename <id> <name>\tRename a device\n")
+		fmt.Fprintf(os.Stderr, "  devices disable <id>\t\tStop a device from receiving messages\n")
+		fmt.Fprintf(os.Stderr, "  devices delete <id>\t\tDelete a device\n")
+		fmt.Fprintf(os.Stderr, "  keygen\t\t\t\tPrint a random key for Key and push-send -key\n")
+		fmt.Fprintf(os.Stderr, "  keys\t\t\t\tPrint the public key of each account, creating missing keys\n")
+		fmt.Fprintf(os.Stderr, "  status\t\t\t\tShow the state of each account\n\n")
+		fmt.Fprintf(os.Stderr, "Without a command the client is started.\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
 }
 
 func main() {
 
 	var wg sync.WaitGroup
 
+	flag.Parse()
+
+	// Needs no config, so it can be run before one exists
+	if flag.Arg(0) == "keygen" {
+
+		key, err := pushover.GenerateKey()
+		if err != nil {
+
+			log.Errorf("keygen: %s", err)
+			return
+		}
+		fmt.Println(key)
+		return
+	}
+
 	cfg, err := GetCFG(ConfigFile)
 	if err != nil {
 
@@ -224,12 +353,69 @@ func main() {
 		return
 	}
 
+	switch flag.Arg(0) {
+
+	case "":
+
+	case "ack":
+		id, err := strconv.Atoi(flag.Arg(1))
+		if err != nil {
+
+			log.Errorf("ack: invalid message id: %s", err)
+			return
+		}
+
+		err = cfg.AckMessage(id)
+		if err != nil {
+
+			log.Errorf("AckMessage: %s", err)
+			return
+		}
+		log.Infof("[%d]: Acknowledged", id)
+		return
+
+	case "keys":
+		err = cfg.PrintKeys()
+		if err != nil {
+
+			log.Errorf("keys: %s", err)
+		}
+		return
+
+	case "status":
+		cfg.PrintStatus()
+		return
+
+	case "devices":
+		err = cfg.DevicesCommand(flag.Args()[1:])
+		if err != nil {
+
+			log.Errorf("devices: %s", err)
+		}
+		return
+
+	default:
+		flag.Usage()
+		return
+	}
+
+	// Shutdown cleanly on interrupt
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+
+		<-sigs
+		log.Info("Shutting down")
+		cancel()
+	}()
+
 	for i := range cfg.Accounts {
 
 		v := &cfg.Accounts[i]
 
 		wg.Add(1)
-		go cfg.LaunchClient(&wg, v)
+		go cfg.LaunchClient(ctx, &wg, v)
 	}
 
 	wg.Wait()
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+// Return a config with one account of the fake server, saved in a
+// temporary directory
+func testConfig(t *testing.T, srv *pushovertest.Server) *ClientConfig {
+
+	t.Helper()
+
+	dir := t.TempDir()
+	old := ConfigFile
+	ConfigFile = filepath.Join(dir, "config.json")
+	t.Cleanup(func() { ConfigFile = old })
+
+	cfg := &ClientConfig{
+
+		Globals: Globals{
+
+			CacheDir:     dir,
+			DeviceName:   pushovertest.Device,
+			CheckSeconds: MinCheckSeconds,
+		},
+		Accounts: []Account{{
+
+			Username: srv.Email,
+			Password: srv.Password,
+		}},
+	}
+	cfg.setup = func(client *pushover.Client) {
+
+		s := srv.Client()
+		client.BaseUrl = s.BaseUrl
+		client.ClientUrl = s.ClientUrl
+		client.StreamUrl = s.StreamUrl
+	}
+
+	err := cfg.validate()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	cfg.state, err = LoadState(StateFile(ConfigFile))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	err = cfg.Flush(ConfigFile)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return cfg
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"code.google.com/p/go.net/html"
+	"github.com/TheCreeper/OpenPushOver/notification"
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+// Whether the notification server renders body markup, checked once
+var (
+	markupOnce sync.Once
+	markup     bool
+)
+
+var markupEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func bodyMarkup() bool {
+
+	markupOnce.Do(func() {
+
+		caps, err := notification.Capabilities()
+		if err != nil {
+
+			log.Warnf("Capabilities: %s", err)
+		}
+
+		for _, v := range caps {
+
+			if v == "body-markup" {
+
+				markup = true
+			}
+		}
+	})
+
+	return markup
+}
+
+// Return the notification body of a message. Servers that render markup
+// are sent escaped text, with the HTML of the message turned into the
+// tags the notification spec allows and monospace messages set in <tt>.
+func FormatBody(msg pushover.PullMessage) string {
+
+	return formatBody(msg, bodyMarkup())
+}
+
+func formatBody(msg pushover.PullMessage, useMarkup bool) string {
+
+	switch {
+
+	case msg.Html == 1:
+		return ConvertHTML(msg.Message, useMarkup)
+	case !useMarkup:
+		return msg.Message
+	case msg.Monospace == 1:
+		return "<tt>" + markupEscaper.Replace(msg.Message) + "</tt>"
+	}
+
+	return markupEscaper.Replace(msg.Message)
+}
+
+// Convert the Pushover HTML subset into notification markup. Bold, italic,
+// underline and links are kept, font colours are dropped as the spec has
+// no equivalent. Without markup the text is returned with each link url
+// after its text.
+func ConvertHTML(s string, useMarkup bool) string {
+
+	var b strings.Builder
+	var open []string
+	var hrefs []string
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+
+		tt := z.Next()
+		switch tt {
+
+		case html.ErrorToken:
+			// Close whatever the message left open so the markup stays valid
+			if useMarkup {
+
+				for i := len(open) - 1; i >= 0; i-- {
+
+					b.WriteString("</" + open[i] + ">")
+				}
+			}
+			return b.String()
+
+		case html.TextToken:
+			if useMarkup {
+
+				b.WriteString(markupEscaper.Replace(string(z.Text())))
+			} else {
+
+				b.Write(z.Text())
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := string(name)
+
+			switch tag {
+
+			case "br":
+				b.WriteString("\n")
+
+			case "b", "i", "u":
+				if tt == html.SelfClosingTagToken {
+
+					continue
+				}
+				open = append(open, tag)
+				if useMarkup {
+
+					b.WriteString("<" + tag + ">")
+				}
+
+			case "a":
+				if tt == html.SelfClosingTagToken {
+
+					continue
+				}
+
+				var href string
+				for hasAttr {
+
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					if string(k) == "href" {
+
+						href = string(v)
+					}
+				}
+
+				open = append(open, tag)
+				hrefs = append(hrefs, href)
+				if useMarkup {
+
+					b.WriteString(`<a href="` + markupEscaper.Replace(href) + `">`)
+				}
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+
+			// Ignore end tags that were never opened
+			i := len(open) - 1
+			for i >= 0 && open[i] != tag {
+
+				i--
+			}
+			if i < 0 {
+
+				continue
+			}
+
+			// Close any tags still open inside this one
+			for j := len(open) - 1; j >= i; j-- {
+
+				if useMarkup {
+
+					b.WriteString("</" + open[j] + ">")
+				} else if open[j] == "a" && len(hrefs[len(hrefs)-1]) > 0 {
+
+					b.WriteString(" (" + hrefs[len(hrefs)-1] + ")")
+				}
+				if open[j] == "a" {
+
+					hrefs = hrefs[:len(hrefs)-1]
+				}
+			}
+			open = open[:i]
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+func TestConvertHTML(t *testing.T) {
+
+	cases := []struct {
+		name   string
+		in     string
+		markup string // With body markup
+		plain  string // Without
+	}{
+		{"allowed tags", "<b>bold</b>, <i>italic</i> and <u>underlined</u>", "<b>bold</b>, <i>italic</i> and <u>underlined</u>", "bold, italic and underlined"},
+		{"font colour", `<font color="#ff0000">red</font> text`, "red text", "red text"},
+		{"other tags", `<img src="x.png">picture <span>here</span>`, "picture here", "picture here"},
+		{"entities", "a &amp; b &lt;c&gt; &quot;d&quot;", "a &amp; b &lt;c&gt; &quot;d&quot;", `a & b <c> "d"`},
+		{"link", `see <a href="https://example.com/?a=1&amp;b=2">this</a>`, `see <a href="https://example.com/?a=1&amp;b=2">this</a>`, "see this (https://example.com/?a=1&b=2)"},
+		{"link without href", "<a>this</a>", `<a href="">this</a>`, "this"},
+		{"line break", "one<br>two<br/>three", "one\ntwo\nthree", "one\ntwo\nthree"},
+		{"left open", "<b>bold <i>both", "<b>bold <i>both</i></b>", "bold both"},
+		{"stray end tag", "text</b>", "text", "text"},
+		{"crossed tags", "<b><i>x</b>y</i>", "<b><i>x</i></b>y", "xy"},
+		{"self closing", "<b/>text", "text", "text"},
+	}
+
+	for _, v := range cases {
+
+		if got := ConvertHTML(v.in, true); got != v.markup {
+
+			t.Errorf("%s: got %q with markup, want %q", v.name, got, v.markup)
+		}
+		if got := ConvertHTML(v.in, false); got != v.plain {
+
+			t.Errorf("%s: got %q without markup, want %q", v.name, got, v.plain)
+		}
+	}
+}
+
+func TestFormatBody(t *testing.T) {
+
+	cases := []struct {
+		name   string
+		msg    pushover.PullMessage
+		markup string
+		plain  string
+	}{
+		{"text", pushover.PullMessage{Message: "a < b"}, "a &lt; b", "a < b"},
+		{"html", pushover.PullMessage{Message: "<b>a</b> &lt; b", Html: 1}, "<b>a</b> &lt; b", "a < b"},
+		{"monospace", pushover.PullMessage{Message: "a < b", Monospace: 1}, "<tt>a &lt; b</tt>", "a < b"},
+	}
+
+	for _, v := range cases {
+
+		if got := formatBody(v.msg, true); got != v.markup {
+
+			t.Errorf("%s: got %q with markup, want %q", v.name, got, v.markup)
+		}
+		if got := formatBody(v.msg, false); got != v.plain {
+
+			t.Errorf("%s: got %q without markup, want %q", v.name, got, v.plain)
+		}
+	}
+}
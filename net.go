@@ -2,9 +2,11 @@ package main
 
 import (
 	"net"
+	"net/http"
 	"time"
 
 	"code.google.com/p/go.net/proxy"
+	"github.com/TheCreeper/OpenPushOver/pushover"
 )
 
 type ConnHandler struct {
@@ -43,3 +45,26 @@ func (handler *ConnHandler) HandleConnection(network, addr string) (conn net.Con
 
 	return forwardDialer.Dial(network, addr)
 }
+
+// Return the http client shared by every account using the named proxy, so
+// they draw from one connection pool. The first caller supplies the dial.
+// Requests are limited to pushover.RequestTimeout so none can hang.
+func (cfg *ClientConfig) HTTPClient(proxyName string, dial func(network, addr string) (net.Conn, error)) *http.Client {
+
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	if cfg.httpClients == nil {
+
+		cfg.httpClients = make(map[string]*http.Client)
+	}
+
+	c, ok := cfg.httpClients[proxyName]
+	if !ok {
+
+		c = pushover.NewHTTPClient(dial, nil)
+		cfg.httpClients[proxyName] = c
+	}
+
+	return c
+}
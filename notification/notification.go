@@ -9,11 +9,20 @@ type Message struct {
 	Title      string
 	Body       string
 	Icon       string
+	Image      string // Absolute path of an image shown in the notification body
 	Urgency    string
-	ExpireTime int
+	ExpireTime int // Milliseconds before the server hides the notification, if it honours it
 	Category   string
 	Hint       string
 	Sound      string
+
+	Actions []Action // Buttons shown on the notification
+}
+
+// A button on the notification. Name is returned by PushAction when clicked.
+type Action struct {
+	Name  string
+	Label string
 }
 
 const (
@@ -1,18 +1,14 @@
 package notification
 
 import (
+	"bytes"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-func (m *Message) Push() (err error) {
-
-	if (len(m.Title) < 1) && (len(m.Body) < 1) {
-
-		return ErrTitleMsg
-	}
-
-	var args []string
+func (m *Message) args() (args []string) {
 
 	if len(m.Title) > 1 {
 
@@ -37,7 +33,7 @@ func (m *Message) Push() (err error) {
 
 	if m.ExpireTime > 1 {
 
-		args = append(args, "--expire-time="+string(m.ExpireTime))
+		args = append(args, "--expire-time="+strconv.Itoa(m.ExpireTime))
 	}
 
 	if len(m.Category) > 1 {
@@ -50,7 +46,27 @@ func (m *Message) Push() (err error) {
 		args = append(args, "--hint="+m.Hint)
 	}
 
-	cmd := exec.Command("notify-send", args...)
+	if len(m.Image) > 1 {
+
+		args = append(args, "--hint=string:image-path:"+filepath.Clean(m.Image))
+	}
+
+	for _, v := range m.Actions {
+
+		args = append(args, "--action="+v.Name+"="+v.Label)
+	}
+
+	return
+}
+
+func (m *Message) Push() (err error) {
+
+	if (len(m.Title) < 1) && (len(m.Body) < 1) {
+
+		return ErrTitleMsg
+	}
+
+	cmd := exec.Command("notify-send", m.args()...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 
@@ -65,6 +81,93 @@ func (m *Message) Push() (err error) {
 	return
 }
 
+// Push the notification and return its id for Close. Needs a notify-send
+// recent enough to support --print-id.
+func (m *Message) PushID() (id uint32, err error) {
+
+	if (len(m.Title) < 1) && (len(m.Body) < 1) {
+
+		return 0, ErrTitleMsg
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("notify-send", append(m.args(), "--print-id")...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+
+		return 0, &NotificationErr{Return: stderr.String(), Err: err}
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(stdout.String()), 10, 32)
+	if err != nil {
+
+		return 0, &NotificationErr{Return: stdout.String(), Err: err}
+	}
+
+	if len(m.Sound) > 1 {
+
+		err = m.PlaySound()
+	}
+
+	return uint32(n), err
+}
+
+// Close a notification pushed with PushID
+func Close(id uint32) (err error) {
+
+	cmd := exec.Command("gdbus", "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.CloseNotification",
+		strconv.FormatUint(uint64(id), 10))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+
+		return &NotificationErr{File: "gdbus", Return: string(out), Err: err}
+	}
+
+	return
+}
+
+// Push the notification and block until it is closed. Returns the name of
+// the action that was clicked, if any.
+func (m *Message) PushAction() (action string, err error) {
+
+	if (len(m.Title) < 1) && (len(m.Body) < 1) {
+
+		return "", ErrTitleMsg
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("notify-send", m.args()...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// notify-send waits for the notification to close when actions are
+	// given, so play the sound while it is showing
+	err = cmd.Start()
+	if err != nil {
+
+		return "", &NotificationErr{Err: err}
+	}
+
+	if len(m.Sound) > 1 {
+
+		m.PlaySound()
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+
+		return "", &NotificationErr{Return: stderr.String(), Err: err}
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
 func (m *Message) PlaySound() (err error) {
 
 	cmd := exec.Command("paplay", m.Sound)
@@ -76,3 +179,30 @@ func (m *Message) PlaySound() (err error) {
 
 	return
 }
+
+// Return the capabilities of the notification server, such as "actions"
+// and "body-markup"
+func Capabilities() (caps []string, err error) {
+
+	cmd := exec.Command("gdbus", "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.GetCapabilities")
+	out, err := cmd.Output()
+	if err != nil {
+
+		return nil, &NotificationErr{File: "gdbus", Err: err}
+	}
+
+	// The reply looks like (['actions', 'body', 'body-markup'],)
+	for _, v := range strings.Split(string(out), ",") {
+
+		v = strings.Trim(strings.TrimSpace(v), "()[]'")
+		if len(v) > 0 {
+
+			caps = append(caps, v)
+		}
+	}
+
+	return
+}
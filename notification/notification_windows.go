@@ -4,3 +4,23 @@ func (m *Message) Push() (err error) {
 
 	return
 }
+
+func (m *Message) PushID() (id uint32, err error) {
+
+	return
+}
+
+func Close(id uint32) (err error) {
+
+	return
+}
+
+func (m *Message) PushAction() (action string, err error) {
+
+	return
+}
+
+func Capabilities() (caps []string, err error) {
+
+	return
+}
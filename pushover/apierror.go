@@ -0,0 +1,130 @@
+package pushover
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Causes of an APIError. Match them with errors.Is.
+var (
+	ErrInvalidToken  = errors.New("Application token is invalid")
+	ErrInvalidUser   = errors.New("User key is invalid")
+	ErrInvalidDevice = errors.New("Device is invalid")
+	ErrSecretExpired = errors.New("Device secret is invalid or has expired")
+	ErrTwoFactor     = errors.New("Two-factor authentication code required")
+	ErrRateLimited   = errors.New("Rate limit exceeded")
+	ErrServer        = errors.New("Pushover is unavailable")
+)
+
+// An error response from the API. The errors array, status and request id
+// from the body are kept along with the HTTP status.
+type APIError struct {
+	Op         error             // The failed call, such as ErrPullMsg
+	StatusCode int               // HTTP status code
+	Status     int               // API status, 1 on success
+	Request    string            // Request id to quote to Pushover support
+	Errors     []string          // Error messages from the errors array
+	Fields     map[string]string // Per field errors, such as "token": "invalid"
+	RetryAfter time.Duration     // From the Retry-After header, 0 if not sent
+}
+
+func (e *APIError) Error() string {
+
+	msg := e.Op.Error()
+	if len(e.Errors) > 0 {
+
+		msg += ": " + strings.Join(e.Errors, ", ")
+	}
+
+	return fmt.Sprintf("%s (http %d, request %s)", msg, e.StatusCode, e.Request)
+}
+
+// Report whether the error is the failed call or one of its causes
+func (e *APIError) Is(target error) bool {
+
+	switch target {
+
+	case e.Op:
+		return true
+	case ErrInvalidToken:
+		return e.Fields["token"] == "invalid"
+	case ErrInvalidUser:
+		return e.Fields["user"] == "invalid"
+	case ErrInvalidDevice:
+		return e.Fields["device"] == "invalid" || e.Fields["device_id"] == "invalid"
+	case ErrSecretExpired:
+		return e.Fields["secret"] == "invalid"
+	case ErrTwoFactor:
+		return e.StatusCode == http.StatusPreconditionFailed
+	case ErrNotLicensed:
+		return e.hasError("licens")
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+
+	return false
+}
+
+// Report whether the same request may succeed later
+func (e *APIError) Temporary() bool {
+
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func (e *APIError) hasError(s string) bool {
+
+	for _, v := range e.Errors {
+
+		if strings.Contains(strings.ToLower(v), s) {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Build an APIError from a response. The body is decoded when it holds
+// JSON and ignored otherwise, as with errors from a proxy or load balancer.
+func newAPIError(op error, statusCode int, body []byte) *APIError {
+
+	e := &APIError{
+
+		Op:         op,
+		StatusCode: statusCode,
+		Fields:     make(map[string]string),
+	}
+
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(body, &raw) != nil {
+
+		return e
+	}
+
+	for k, v := range raw {
+
+		switch k {
+
+		case "status":
+			json.Unmarshal(v, &e.Status)
+		case "request":
+			json.Unmarshal(v, &e.Request)
+		case "errors":
+			json.Unmarshal(v, &e.Errors)
+		default:
+			var s string
+			if json.Unmarshal(v, &s) == nil {
+
+				e.Fields[k] = s
+			}
+		}
+	}
+
+	return e
+}
@@ -0,0 +1,111 @@
+package pushover_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+// Error bodies as sent by the Pushover API, and the causes they map to
+func TestAPIErrorCauses(t *testing.T) {
+
+	all := []error{
+
+		pushover.ErrInvalidToken,
+		pushover.ErrInvalidUser,
+		pushover.ErrInvalidDevice,
+		pushover.ErrSecretExpired,
+		pushover.ErrTwoFactor,
+		pushover.ErrNotLicensed,
+		pushover.ErrRateLimited,
+		pushover.ErrServer,
+	}
+
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"token", 400, `{"token":"invalid","errors":["application token is invalid"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`, pushover.ErrInvalidToken},
+		{"user", 400, `{"user":"invalid","errors":["user identifier is not a valid user, group, or subscribed user key"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`, pushover.ErrInvalidUser},
+		{"device", 400, `{"device":"invalid","errors":["device name is not valid for user"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`, pushover.ErrInvalidDevice},
+		{"device_id", 400, `{"device_id":"invalid","errors":["device id is invalid"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`, pushover.ErrInvalidDevice},
+		{"secret", 400, `{"secret":"invalid","errors":["secret is invalid"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`, pushover.ErrSecretExpired},
+		{"two-factor", 412, `{"errors":["two-factor authentication is enabled, please supply a twofa code"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`, pushover.ErrTwoFactor},
+		{"licence", 400, `{"errors":["this device is not licensed, please purchase a license"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`, pushover.ErrNotLicensed},
+		{"rate limit", 429, `{"errors":["application is over its monthly message limit"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`, pushover.ErrRateLimited},
+		{"server", 500, `{"errors":["an internal error occurred"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`, pushover.ErrServer},
+		{"proxy", 502, `<html><body>Bad Gateway</body></html>`, pushover.ErrServer},
+	}
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.SetSecret(srv.Secret)
+	c.DeviceUUID = srv.DeviceID
+
+	for _, v := range cases {
+
+		srv.Script("/1/messages.json", pushovertest.Response{Status: v.status, Body: v.body})
+
+		_, err := c.FetchMessages()
+		if !errors.Is(err, pushover.ErrPullMsg) {
+
+			t.Errorf("%s: got %v, want ErrPullMsg", v.name, err)
+		}
+
+		for _, cause := range all {
+
+			if got := errors.Is(err, cause); got != (cause == v.want) {
+
+				t.Errorf("%s: errors.Is(%v) is %v", v.name, cause, got)
+			}
+		}
+
+		var apiErr *pushover.APIError
+		if !errors.As(err, &apiErr) {
+
+			t.Errorf("%s: got %T, want an APIError", v.name, err)
+			continue
+		}
+		if apiErr.StatusCode != v.status || apiErr.Op != pushover.ErrPullMsg {
+
+			t.Errorf("%s: got %+v", v.name, apiErr)
+		}
+		if v.name != "proxy" && (apiErr.Request != "5042853c-402d-4a18-abcb-168734a801de" || len(apiErr.Errors) != 1) {
+
+			t.Errorf("%s: body not decoded: %+v", v.name, apiErr)
+		}
+	}
+}
+
+func TestAPIErrorRetryAfter(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	srv.Script("/1/messages.json", pushovertest.Response{
+
+		Status: http.StatusTooManyRequests,
+		Header: http.Header{"Retry-After": {"30"}},
+		Body:   `{"errors":["too many requests"],"status":0}`,
+	})
+
+	err := srv.Client().Push("hello")
+
+	var apiErr *pushover.APIError
+	if !errors.As(err, &apiErr) {
+
+		t.Fatalf("got %v, want an APIError", err)
+	}
+	if apiErr.RetryAfter != 30*time.Second || !apiErr.Temporary() {
+
+		t.Fatalf("got %+v", apiErr)
+	}
+}
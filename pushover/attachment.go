@@ -0,0 +1,150 @@
+package pushover
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An image sent along with a pushed message
+type Attachment struct {
+	Reader io.Reader // Image data, read once when the message is pushed
+	Name   string    // File name shown to the recipient
+	Type   string    // MIME type, detected from Name or the data when empty
+}
+
+// A file part of a multipart/form-data request. The data is kept in memory
+// so the request can be sent again when retried.
+type formFile struct {
+	field       string
+	name        string
+	contentType string
+	data        []byte
+}
+
+// Return the size of the attachment if it can be known without reading it
+func (a *Attachment) size() (n int64, ok bool) {
+
+	switch r := a.Reader.(type) {
+
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case interface{ Stat() (os.FileInfo, error) }:
+		fi, err := r.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+
+			return 0, false
+		}
+		return fi.Size(), true
+	}
+
+	return 0, false
+}
+
+// Read the attachment into a form file, enforcing AttachmentLimit for
+// readers whose size could not be checked up front
+func (a *Attachment) read() (f formFile, err error) {
+
+	if a.Reader == nil {
+
+		return f, ErrAttachment
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(a.Reader, AttachmentLimit+1))
+	if err != nil {
+
+		return
+	}
+	if len(data) > AttachmentLimit {
+
+		return f, ErrAttachmentLimit
+	}
+	if len(data) < 1 {
+
+		return f, ErrAttachment
+	}
+
+	contentType := a.Type
+	if len(contentType) < 1 {
+
+		contentType = mime.TypeByExtension(filepath.Ext(a.Name))
+	}
+	if len(contentType) < 1 {
+
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+
+		return f, ErrAttachmentType
+	}
+
+	name := filepath.Base(a.Name)
+	if len(a.Name) < 1 {
+
+		name = "attachment"
+	}
+
+	return formFile{field: "attachment", name: name, contentType: contentType, data: data}, nil
+}
+
+// Encode the form values and files as a multipart/form-data body
+func multipartBody(vars url.Values, files []formFile) (body *bytes.Buffer, contentType string, err error) {
+
+	body = &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+
+	for k, vs := range vars {
+
+		for _, v := range vs {
+
+			err = w.WriteField(k, v)
+			if err != nil {
+
+				return
+			}
+		}
+	}
+
+	for _, f := range files {
+
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, f.field, escapeQuotes(f.name)))
+		h.Set("Content-Type", f.contentType)
+
+		part, err := w.CreatePart(h)
+		if err != nil {
+
+			return nil, "", err
+		}
+
+		_, err = part.Write(f.data)
+		if err != nil {
+
+			return nil, "", err
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+
+		return
+	}
+
+	return body, w.FormDataContentType(), nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+
+	return quoteEscaper.Replace(s)
+}
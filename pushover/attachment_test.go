@@ -0,0 +1,128 @@
+package pushover_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+// The header of a png followed by padding
+var png = append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+
+// The message is sent as multipart/form-data with the image as a file part
+func TestAttachmentMultipart(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+
+	msg := pushover.PushMessage{
+
+		Message:    "hello",
+		Title:      "Title",
+		Attachment: &pushover.Attachment{Reader: bytes.NewReader(png), Name: "dir/shot.png"},
+	}
+	err := c.PushMessage(msg, false)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	a, ok := srv.PushedAttachment(0)
+	if !ok {
+
+		t.Fatal("server received no attachment")
+	}
+	if a.Name != "shot.png" || a.Type != "image/png" || !bytes.Equal(a.Data, png) {
+
+		t.Fatalf("got attachment %q of type %q", a.Name, a.Type)
+	}
+
+	form := srv.Pushed()[0]
+	if form.Get("message") != "hello" || form.Get("title") != "Title" || form.Get("token") != srv.AppToken {
+
+		t.Fatalf("got form %v", form)
+	}
+
+	// A reader of unknown size, with the type taken from the data
+	msg.Attachment = &pushover.Attachment{Reader: io.MultiReader(bytes.NewReader(png))}
+	err = c.PushMessage(msg, false)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	a, ok = srv.PushedAttachment(1)
+	if !ok || a.Name != "attachment" || a.Type != "image/png" {
+
+		t.Fatalf("got attachment %q of type %q", a.Name, a.Type)
+	}
+}
+
+func TestAttachmentRejected(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+	big := make([]byte, pushover.AttachmentLimit+1)
+
+	cases := []struct {
+		name       string
+		attachment *pushover.Attachment
+		err        error
+	}{
+		{"no reader", &pushover.Attachment{Name: "shot.png"}, pushover.ErrAttachment},
+		{"empty", &pushover.Attachment{Reader: bytes.NewReader(nil)}, pushover.ErrAttachment},
+		{"too large", &pushover.Attachment{Reader: bytes.NewReader(big)}, pushover.ErrAttachmentLimit},
+		{"too large, unknown size", &pushover.Attachment{Reader: io.MultiReader(bytes.NewReader(big))}, pushover.ErrAttachmentLimit},
+		{"text", &pushover.Attachment{Reader: strings.NewReader("hello"), Name: "notes.txt"}, pushover.ErrAttachmentType},
+		{"text, detected", &pushover.Attachment{Reader: strings.NewReader("hello")}, pushover.ErrAttachmentType},
+		{"wrong type", &pushover.Attachment{Reader: bytes.NewReader(png), Type: "application/pdf"}, pushover.ErrAttachmentType},
+	}
+
+	for _, v := range cases {
+
+		err := c.PushMessage(pushover.PushMessage{Message: "hello", Attachment: v.attachment}, false)
+		if err != v.err {
+
+			t.Errorf("%s: got %v, want %v", v.name, err, v.err)
+		}
+	}
+
+	if n := len(srv.Pushed()); n != 0 {
+
+		t.Fatalf("server received %d messages, want 0", n)
+	}
+}
+
+// The attachment is read once but sent again on a retry
+func TestAttachmentRetry(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	srv.Script("/1/messages.json", pushovertest.Response{Status: http.StatusServiceUnavailable, Body: `{"status":0}`})
+
+	c := srv.Client()
+	c.Retry = &pushover.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	err := c.PushMessage(pushover.PushMessage{Message: "hello", Attachment: &pushover.Attachment{Reader: bytes.NewReader(png), Name: "shot.png"}}, false)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	a, ok := srv.PushedAttachment(0)
+	if !ok || !bytes.Equal(a.Data, png) {
+
+		t.Fatal("retried push lost its attachment")
+	}
+}
@@ -0,0 +1,88 @@
+package pushover_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+// One Client shared by goroutines fetching, pushing, renaming and
+// registering at once. Run with -race to catch unguarded state.
+func TestClientConcurrentUse(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := loggedIn(t, srv)
+	c.DeviceName = "desktop"
+
+	err := c.RegisterDevice()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	srv.QueueMessage(pushover.PullMessage{ID: 1, Title: "Title", Message: "Body"})
+
+	ctx := context.Background()
+	errs := make(chan error, 100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+
+		wg.Add(4)
+		go func() {
+
+			defer wg.Done()
+
+			msgs, err := c.FetchMessagesContext(ctx)
+			if err == nil && len(msgs.Messages) != 1 {
+
+				err = fmt.Errorf("fetched %d messages, want 1", len(msgs.Messages))
+			}
+			errs <- err
+		}()
+		go func(i int) {
+
+			defer wg.Done()
+
+			errs <- c.PushMessage(pushover.PushMessage{Message: fmt.Sprintf("message %d", i)}, false)
+		}(i)
+		go func(i int) {
+
+			defer wg.Done()
+
+			_, err := c.RenameDeviceContext(ctx, srv.DeviceID, fmt.Sprintf("desktop%d", i))
+			errs <- err
+		}(i)
+		go func() {
+
+			defer wg.Done()
+
+			_, err := c.RegisterDeviceContext(ctx)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+
+		if err != nil {
+
+			t.Error(err)
+		}
+	}
+
+	if n := len(srv.Pushed()); n != 5 {
+
+		t.Fatalf("server received %d messages, want 5", n)
+	}
+	if secret := c.Secret(); secret != srv.Secret {
+
+		t.Fatalf("secret changed to %q", secret)
+	}
+}
@@ -0,0 +1,171 @@
+package pushover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// Errors
+var (
+	ErrListDevices   = errors.New("Unable to list devices")
+	ErrRenameDevice  = errors.New("Unable to rename device")
+	ErrDisableDevice = errors.New("Unable to disable device")
+	ErrDeleteDevice  = errors.New("Unable to delete device")
+	ErrVerifyDevice  = fmt.Errorf("Device id must contain between 1 and %d letters, numbers or dashes", DeviceUUIDLimit)
+)
+
+// Device ids go into the request path, so nothing else is let through
+var deviceID = regexp.MustCompile("^[A-Za-z0-9-]+$")
+
+// A device registered to the user
+type Device struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	OS       string `json:"os"`       // Single char such as A (Android) or O (Open Client)
+	Disabled bool   `json:"disabled"` // Device no longer receives messages
+}
+
+type DevicesResponse struct {
+	Devices []Device `json:"devices"`
+
+	Status  int      `json:"status"`
+	Request string   `json:"request"`
+	Errors  []string `json:"errors"`
+}
+
+func verifyDeviceID(id string) error {
+
+	if (len(id) < 1) || (len(id) > DeviceUUIDLimit) || !deviceID.MatchString(id) {
+
+		return ErrVerifyDevice
+	}
+
+	return nil
+}
+
+// Send a request to devices/{id}/{action}.json with the session secret
+func (c *Client) deviceCall(ctx context.Context, id, action string, vars url.Values, op error) (resp DevicesResponse, err error) {
+
+	secret, _ := c.session()
+	if len(secret) < 1 {
+
+		err = ErrDeviceAuth
+		return
+	}
+
+	err = verifyDeviceID(id)
+	if err != nil {
+
+		return
+	}
+
+	vars.Add("secret", secret)
+
+	urlF := fmt.Sprintf("%s/devices/%s/%s.json", c.baseUrl(), id, action)
+	_, err = c.doJSON(ctx, "POST", urlF, vars, op, &resp)
+	return
+}
+
+// List the devices registered to the logged in user
+func (c *Client) ListDevices() ([]Device, error) {
+
+	return c.ListDevicesContext(context.Background())
+}
+
+// ListDevicesContext is like ListDevices but uses ctx for cancellation and deadlines
+func (c *Client) ListDevicesContext(ctx context.Context) (devices []Device, err error) {
+
+	secret, _ := c.session()
+	if len(secret) < 1 {
+
+		err = ErrDeviceAuth
+		return
+	}
+
+	var resp DevicesResponse
+	urlF := fmt.Sprintf("%s/devices.json?secret=%s", c.baseUrl(), url.QueryEscape(secret))
+	_, err = c.doJSON(ctx, "GET", urlF, nil, ErrListDevices, &resp)
+	return resp.Devices, err
+}
+
+func (c *Client) RenameDevice(id, name string) (DevicesResponse, error) {
+
+	return c.RenameDeviceContext(context.Background(), id, name)
+}
+
+// RenameDeviceContext is like RenameDevice but uses ctx for cancellation and deadlines
+func (c *Client) RenameDeviceContext(ctx context.Context, id, name string) (resp DevicesResponse, err error) {
+
+	if len(name) < 1 {
+
+		err = ErrVerifyDeviceName
+		return
+	}
+
+	err = VerifyDeviceName(name)
+	if err != nil {
+
+		return
+	}
+
+	vars := url.Values{}
+	vars.Add("name", name)
+
+	resp, err = c.deviceCall(ctx, id, "rename", vars, ErrRenameDevice)
+	if err != nil {
+
+		return
+	}
+
+	// Keep DeviceName in step when renaming this device
+	c.mu.Lock()
+	if id == c.DeviceUUID {
+
+		c.DeviceName = name
+	}
+	c.mu.Unlock()
+
+	return
+}
+
+// Stop a device from receiving messages. It stays registered and can be
+// enabled again from the Pushover dashboard.
+func (c *Client) DisableDevice(id string) (DevicesResponse, error) {
+
+	return c.DisableDeviceContext(context.Background(), id)
+}
+
+// DisableDeviceContext is like DisableDevice but uses ctx for cancellation and deadlines
+func (c *Client) DisableDeviceContext(ctx context.Context, id string) (DevicesResponse, error) {
+
+	return c.deviceCall(ctx, id, "disable", url.Values{}, ErrDisableDevice)
+}
+
+// Delete a device and its messages. Deleting the device of this Client
+// clears DeviceUUID so it can register again.
+func (c *Client) DeleteDevice(id string) (DevicesResponse, error) {
+
+	return c.DeleteDeviceContext(context.Background(), id)
+}
+
+// DeleteDeviceContext is like DeleteDevice but uses ctx for cancellation and deadlines
+func (c *Client) DeleteDeviceContext(ctx context.Context, id string) (resp DevicesResponse, err error) {
+
+	resp, err = c.deviceCall(ctx, id, "delete", url.Values{}, ErrDeleteDevice)
+	if err != nil {
+
+		return
+	}
+
+	c.mu.Lock()
+	if id == c.DeviceUUID {
+
+		c.DeviceUUID = ""
+	}
+	c.mu.Unlock()
+
+	return
+}
@@ -0,0 +1,89 @@
+package pushover_test
+
+import (
+	"testing"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+func TestDevices(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	other := "otherdevice0123456789"
+	srv.AddDevice(pushover.Device{ID: other, Name: "laptop", OS: "O"})
+
+	c := loggedIn(t, srv)
+	c.DeviceName = "desktop"
+
+	err := c.RegisterDevice()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	devices, err := c.ListDevices()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+
+		t.Fatalf("listed %d devices, want 2", len(devices))
+	}
+
+	_, err = c.RenameDevice(other, "old-laptop")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	_, err = c.DisableDevice(other)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if d, _ := srv.Device(other); d.Name != "old-laptop" || !d.Disabled {
+
+		t.Fatalf("got %+v", d)
+	}
+
+	// Renaming this device updates DeviceName
+	_, err = c.RenameDevice(srv.DeviceID, "workstation")
+	if err != nil || c.DeviceName != "workstation" {
+
+		t.Fatalf("device name is %q, %v", c.DeviceName, err)
+	}
+
+	// Deleting it clears DeviceUUID
+	_, err = c.DeleteDevice(srv.DeviceID)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if _, ok := srv.Device(srv.DeviceID); ok || len(c.DeviceUUID) > 0 {
+
+		t.Fatalf("device not deleted, DeviceUUID is %q", c.DeviceUUID)
+	}
+}
+
+// Device ids go into the request path, so anything that could change the
+// request is refused before it is sent
+func TestDeviceIDs(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := loggedIn(t, srv)
+
+	for _, id := range []string{"", "../messages", "a/b", "a?b=c", "a%2Fb", "a b", "0123456789012345678901234567890123456"} {
+
+		_, err := c.DeleteDevice(id)
+		if err != pushover.ErrVerifyDevice {
+
+			t.Errorf("%q: got %v, want ErrVerifyDevice", id, err)
+		}
+	}
+}
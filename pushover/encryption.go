@@ -2,20 +2,76 @@ package pushover
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"regexp"
+	"strings"
+	"time"
 
+	"code.google.com/p/go.crypto/curve25519"
+	"code.google.com/p/go.crypto/nacl/box"
 	"code.google.com/p/go.crypto/nacl/secretbox"
+	"code.google.com/p/go.crypto/scrypt"
 )
 
 // Encryption Limits
 const (
 	keySize   = 32
 	nonceSize = 24
+	keyIDSize = 8
+	saltSize  = 16
 )
 
+// Cost of deriving a key from a passphrase with scrypt
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Encrypted messages are sent as "@Sealed@ " followed by a base64
+// (standard encoding, padded) envelope:
+//
+//	version     1 byte   EnvelopeVersion
+//	scheme      1 byte   SchemeSecretBox or SchemeBox
+//	id length   1 byte
+//	key id      n bytes  SchemeSecretBox: ID of the key in the Keyring of the
+//	                     sender. Without one, the 8 byte fingerprint of a raw
+//	                     key, or nothing for a passphrase.
+//	                     SchemeBox: public key of the sender
+//	salt length 1 byte   0, or 16 when the key was derived from a passphrase
+//	salt        n bytes
+//	nonce       24 bytes
+//	data        rest     secretbox or box ciphertext of the message body
+//
+// A Key of 32 bytes written as hex or base64 is used as it is. Any other
+// Key is a passphrase, stretched with scrypt (N=32768, r=8, p=1) and a
+// random salt for every message. The fingerprint of a raw key is the first 8
+// bytes of its SHA-256. Passphrases get no fingerprint, as one taken with a
+// fixed salt could be matched against a precomputed dictionary, so receivers
+// try each passphrase without an id with the salt of the message.
+//
+// Envelopes are parsed strictly, anything that does not match the layout
+// above is rejected rather than guessed at. Messages from older clients used
+// the "@Enc@", "@Encrypted@" and "@Box@" prefixes without a version and are
+// rejected with ErrEnvelopeLegacy.
+const (
+	EnvelopeVersion = 1
+
+	SchemeSecretBox = 1 // Shared Key
+	SchemeBox       = 2 // PrivateKey of the sender and public key of the recipient
+
+	envelopePrefix = "@Sealed@ "
+)
+
+// Body of a message that could not be decrypted, followed by the reason
+const UndecryptableMessage = "[Undecryptable message]"
+
+// Prefixes used by clients from before the envelope was versioned
+var legacyPrefixes = []string{"@Encrypted@", "@Enc@", "@Box@"}
+
 // Errors
 var (
 	ErrMsgNoEnc     = errors.New("Message is not encrypted")
@@ -23,103 +79,665 @@ var (
 	ErrHMAC         = errors.New("Unable to generate HMAC")
 	ErrVerifyHMAC   = errors.New("Unable to verify HMAC")
 	ErrEncodeBase64 = errors.New("Unable to encode to base64")
+	ErrBox          = errors.New("Failed to open box")
+	ErrBoxKey       = fmt.Errorf("Box keys must be %d bytes encoded as base64", keySize)
+	ErrNoKey        = errors.New("No key to encrypt or decrypt the message with")
+	ErrSender       = errors.New("Message was boxed by an unknown sender")
+	ErrKeyID        = errors.New("Message was encrypted with a key this client does not have")
+	ErrKeyExpired   = errors.New("Message was encrypted with a key outside its validity window")
+	ErrKeyring      = errors.New("Keyring keys need a key and a unique id of 1 to 255 bytes")
+	ErrAppKey       = errors.New("App keys need an App or Aid and a Key or Keyring")
+
+	ErrEnvelope        = errors.New("Encrypted message is malformed")
+	ErrEnvelopeVersion = errors.New("Encrypted message uses an unsupported envelope version, upgrade this client")
+	ErrEnvelopeScheme  = errors.New("Encrypted message uses an unknown scheme")
+	ErrEnvelopeLegacy  = errors.New("Encrypted message uses an old format that is no longer accepted, upgrade the sender")
 )
 
-// Some regular expressions
-var (
-
-	// Valid encrypted message.
-	ValidEncMsg = regexp.MustCompile("@Enc@.?")
+// How far outside its validity window a key still opens messages when
+// Client.KeyGrace is not set, to allow for delays and clock skew
+const (
+	DefaultKeyGrace = time.Hour
 )
 
-func isEncrypted(msg string) bool {
+// A shared key in a Keyring. Senders seal with the newest key that is valid
+// now and receivers open a message with the key named in its envelope, as
+// long as it is received while the key is valid. The date of a message is
+// set by its sender, so it is not trusted for this.
+type SharedKey struct {
+	ID        string    // Sent in the envelope so receivers can pick the key
+	Key       string    // Raw key or passphrase, as Client.Key
+	NotBefore time.Time // Key is not valid before this time, if set
+	NotAfter  time.Time // Key is not valid after this time, if set
+}
+
+// Return true if the key may be used at t
+func (k SharedKey) Valid(t time.Time) bool {
+
+	if !k.NotBefore.IsZero() && t.Before(k.NotBefore) {
+
+		return false
+	}
+
+	if !k.NotAfter.IsZero() && t.After(k.NotAfter) {
+
+		return false
+	}
+
+	return true
+}
+
+// Return true if a message received at t may be opened with the key,
+// allowing grace either side of its validity window
+func (k SharedKey) receivable(t time.Time, grace time.Duration) bool {
+
+	if !k.NotBefore.IsZero() {
+
+		k.NotBefore = k.NotBefore.Add(-grace)
+	}
+
+	if !k.NotAfter.IsZero() {
+
+		k.NotAfter = k.NotAfter.Add(grace)
+	}
+
+	return k.Valid(t)
+}
+
+// Check that every key of a keyring has a key and a unique id
+func VerifyKeyring(keys []SharedKey) error {
+
+	ids := make(map[string]bool)
+	for _, k := range keys {
+
+		if len(k.ID) < 1 || len(k.ID) > 255 || len(k.Key) < 1 || ids[k.ID] {
+
+			return ErrKeyring
+		}
+		ids[k.ID] = true
+	}
+
+	return nil
+}
+
+// The shared keys for the messages of one application, used instead of the
+// Key and Keyring of the Client. A message is matched by Aid when set, then
+// by App.
+type AppKey struct {
+	App     string // Name of the application, as PullMessage.App
+	Aid     int    // Id of the application, as PullMessage.Aid
+	Key     string
+	Keyring []SharedKey
+}
+
+// Check that every app key names an application and has keys
+func VerifyAppKeys(keys []AppKey) error {
+
+	for _, k := range keys {
+
+		if (len(k.App) < 1 && k.Aid < 1) || (len(k.Key) < 1 && len(k.Keyring) < 1) {
+
+			return ErrAppKey
+		}
+
+		err := VerifyKeyring(k.Keyring)
+		if err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Return the shared keys for a message from an application, falling back
+// to Key and Keyring when there are none for it
+func (c *Client) appKey(app string, aid int) AppKey {
+
+	for _, k := range c.AppKeys {
+
+		if k.Aid > 0 && k.Aid == aid {
+
+			return k
+		}
+	}
+
+	for _, k := range c.AppKeys {
+
+		if k.Aid < 1 && len(k.App) > 0 && k.App == app {
+
+			return k
+		}
+	}
+
+	return AppKey{Key: c.Key, Keyring: c.Keyring}
+}
+
+// A parsed encryption envelope
+type envelope struct {
+	Version byte
+	Scheme  byte
+	KeyID   []byte
+	Salt    []byte
+	Nonce   [nonceSize]byte
+	Data    []byte
+}
+
+// Return the envelope as message text
+func (e *envelope) encode() (s string, err error) {
+
+	if len(e.KeyID) > 255 || len(e.Salt) > 255 {
 
-	return ValidEncMsg.MatchString(msg)
+		return "", ErrEnvelope
+	}
+
+	b := []byte{e.Version, e.Scheme, byte(len(e.KeyID))}
+	b = append(b, e.KeyID...)
+	b = append(b, byte(len(e.Salt)))
+	b = append(b, e.Salt...)
+	b = append(b, e.Nonce[:]...)
+	b = append(b, e.Data...)
+
+	s, err = encodeBase64String(b)
+	if err != nil {
+
+		return
+	}
+
+	return envelopePrefix + s, nil
+}
+
+// Parse message text into an envelope
+func parseEnvelope(s string) (e envelope, err error) {
+
+	if !strings.HasPrefix(s, envelopePrefix) {
+
+		for _, v := range legacyPrefixes {
+
+			if strings.HasPrefix(s, v) {
+
+				return e, ErrEnvelopeLegacy
+			}
+		}
+		return e, ErrMsgNoEnc
+	}
+
+	b, err := base64.StdEncoding.Strict().DecodeString(s[len(envelopePrefix):])
+	if err != nil || len(b) < 3 {
+
+		return e, ErrEnvelope
+	}
+
+	e.Version, e.Scheme = b[0], b[1]
+	if e.Version != EnvelopeVersion {
+
+		return e, ErrEnvelopeVersion
+	}
+
+	var idSize, overhead int
+	var salted bool
+	switch e.Scheme {
+
+	case SchemeSecretBox:
+		idSize, overhead, salted = int(b[2]), secretbox.Overhead, true
+	case SchemeBox:
+		idSize, overhead = keySize, box.Overhead
+	default:
+		return e, ErrEnvelopeScheme
+	}
+
+	// Key id, only left out of envelopes sealed with a passphrase
+	if (idSize < 1 && !salted) || int(b[2]) != idSize || len(b) < 3+idSize+1 {
+
+		return e, ErrEnvelope
+	}
+	e.KeyID = b[3 : 3+idSize]
+	b = b[3+idSize:]
+
+	// Salt
+	n := int(b[0])
+	if (n != 0 && !salted) || (n != 0 && n != saltSize) || len(b) < 1+n {
+
+		return e, ErrEnvelope
+	}
+	if n > 0 {
+
+		e.Salt = b[1 : 1+n]
+	}
+	if idSize < 1 && n < 1 {
+
+		return e, ErrEnvelope
+	}
+	b = b[1+n:]
+
+	if len(b) < nonceSize+overhead {
+
+		return e, ErrEnvelope
+	}
+
+	copy(e.Nonce[:], b[:nonceSize])
+	e.Data = b[nonceSize:]
+	return
 }
 
-func decryptMessage(key, s string) (msg string, err error) {
+// Return true if the client has any key to decrypt messages with
+func (c *Client) canDecrypt() bool {
+
+	return len(c.Key) > 0 || len(c.Keyring) > 0 || len(c.AppKeys) > 0 || len(c.PrivateKey) > 0
+}
 
-	var keyBytes [keySize]byte
-	copy(keyBytes[:], key)
+// Return true if the message looks encrypted, in this or an older format
+func isEncrypted(msg string) bool {
 
-	s = ValidEncMsg.ReplaceAllString(s, "")
+	if strings.HasPrefix(msg, envelopePrefix) {
+
+		return true
+	}
+
+	for _, v := range legacyPrefixes {
+
+		if strings.HasPrefix(msg, v) {
+
+			return true
+		}
+	}
+
+	return false
+}
 
-	// Decode message
-	decoded, err := decodeBase64String(s)
+// Decrypt a message with the shared keys of its application or PrivateKey,
+// depending on its scheme
+func (c *Client) decryptMessage(m PullMessage) (msg string, err error) {
+
+	e, err := parseEnvelope(m.Message)
 	if err != nil {
 
 		return
 	}
 
-	// Decrypt message
-	decrypted, err := decrypt(keyBytes, decoded)
+	var out []byte
+	switch e.Scheme {
+
+	case SchemeSecretBox:
+		out, err = c.appKey(m.App, m.Aid).open(e, time.Now(), c.keyGrace())
+	case SchemeBox:
+		out, err = c.openBox(e)
+	}
 	if err != nil {
 
 		return
 	}
 
-	msg = string(decrypted)
-	return
+	return string(out), nil
+}
+
+// Return KeyGrace, or DefaultKeyGrace when it is not set
+func (c *Client) keyGrace() time.Duration {
+
+	if c.KeyGrace != 0 {
+
+		return c.KeyGrace
+	}
+
+	return DefaultKeyGrace
 }
 
-func decrypt(key [keySize]byte, in []byte) (out []byte, err error) {
+// Open a sealed message received at now with the key of the Keyring named in
+// the envelope. Envelopes without a named key are matched against Key and the
+// Keyring by fingerprint, or for passphrases by trying each of them.
+func (ak AppKey) open(e envelope, now time.Time, grace time.Duration) (out []byte, err error) {
+
+	if len(ak.Key) < 1 && len(ak.Keyring) < 1 {
+
+		return nil, ErrNoKey
+	}
+
+	for _, k := range ak.Keyring {
+
+		if k.ID != string(e.KeyID) {
+
+			continue
+		}
+		if !k.receivable(now, grace) {
 
-	var nonce [nonceSize]byte
-	copy(nonce[:], in[:nonceSize])
+			return nil, ErrKeyExpired
+		}
 
-	var ok bool
-	out, ok = secretbox.Open(out, in[nonceSize:], &nonce, &key)
+		_, raw := rawKey(k.Key)
+		if raw != (len(e.Salt) < 1) {
+
+			return nil, ErrSecretBox
+		}
+
+		key, err := deriveKey(k.Key, e.Salt)
+		if err != nil {
+
+			return nil, err
+		}
+		return openSealed(e, key)
+	}
+
+	keys := append([]SharedKey{{Key: ak.Key}}, ak.Keyring...)
+	for _, k := range keys {
+
+		_, raw := rawKey(k.Key)
+		if len(k.Key) < 1 || raw != (len(e.Salt) < 1) {
+
+			continue
+		}
+
+		if string(e.KeyID) != string(keyID(k.Key)) {
+
+			continue
+		}
+
+		key, err := deriveKey(k.Key, e.Salt)
+		if err != nil {
+
+			return nil, err
+		}
+
+		// Only the right passphrase opens the box, so a wrong one is no
+		// reason to stop trying
+		out, err = openSealed(e, key)
+		if err == ErrSecretBox && !raw {
+
+			continue
+		}
+		if err == nil && !k.receivable(now, grace) {
+
+			return nil, ErrKeyExpired
+		}
+		return out, err
+	}
+
+	return nil, ErrKeyID
+}
+
+func openSealed(e envelope, key [keySize]byte) (out []byte, err error) {
+
+	out, ok := secretbox.Open(nil, e.Data, &e.Nonce, &key)
 	if !ok {
 
-		err = ErrSecretBox
+		return nil, ErrSecretBox
+	}
+
+	return
+}
+
+// Return the key to seal a message with at t, and its id if it came from
+// the Keyring
+func (c *Client) sealingKey(t time.Time) (id, key string, err error) {
+
+	var newest *SharedKey
+	for i := range c.Keyring {
+
+		k := &c.Keyring[i]
+		if k.Valid(t) && (newest == nil || k.NotBefore.After(newest.NotBefore)) {
+
+			newest = k
+		}
+	}
+
+	switch {
+
+	case newest != nil:
+		return newest.ID, newest.Key, nil
+	case len(c.Key) > 0:
+		return "", c.Key, nil
+	}
+
+	return "", "", ErrNoKey
+}
+
+// Open a boxed message with PrivateKey. Only senders in SenderKeys are
+// accepted when it is set.
+func (c *Client) openBox(e envelope) (out []byte, err error) {
+
+	if len(c.PrivateKey) < 1 {
+
+		return nil, ErrNoKey
+	}
+
+	priv, err := decodeKey(c.PrivateKey)
+	if err != nil {
+
 		return
 	}
 
+	var peer [keySize]byte
+	copy(peer[:], e.KeyID)
+
+	if !c.knownSender(peer) {
+
+		return nil, ErrSender
+	}
+
+	out, ok := box.Open(nil, e.Data, &e.Nonce, &peer, &priv)
+	if !ok {
+
+		return nil, ErrBox
+	}
+
 	return
 }
 
-func (c *Client) encryptMessage(msg PushMessage) (err error) {
+func (c *Client) knownSender(peer [keySize]byte) bool {
 
-	var key [keySize]byte
-	copy(key[:], c.Key)
+	if len(c.SenderKeys) < 1 {
+
+		return true
+	}
 
-	// Encrypt the message body
-	b, err := encrypt(key, []byte(msg.Message))
+	for _, v := range c.SenderKeys {
+
+		key, err := decodeKey(v)
+		if err == nil && key == peer {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Replace the message body with its envelope. Messages are boxed for
+// RecipientKey when it is set, otherwise they are sealed with the newest
+// valid key of the Keyring, or Key.
+func (c *Client) encryptMessage(msg *PushMessage) (err error) {
+
+	e := envelope{Version: EnvelopeVersion}
+
+	_, e.Nonce, err = newNonce()
 	if err != nil {
 
 		return
 	}
 
-	out, err := encodeBase64String(b)
+	switch {
+
+	case len(c.RecipientKey) > 0:
+		peer, err := decodeKey(c.RecipientKey)
+		if err != nil {
+
+			return err
+		}
+
+		priv, err := decodeKey(c.PrivateKey)
+		if err != nil {
+
+			return err
+		}
+
+		var pub [keySize]byte
+		curve25519.ScalarBaseMult(&pub, &priv)
+
+		e.Scheme = SchemeBox
+		e.KeyID = pub[:]
+		e.Data = box.Seal(nil, []byte(msg.Message), &e.Nonce, &peer, &priv)
+
+	case len(c.Key) > 0 || len(c.Keyring) > 0:
+		id, shared, err := c.sealingKey(time.Now())
+		if err != nil {
+
+			return err
+		}
+
+		if _, raw := rawKey(shared); !raw {
+
+			e.Salt = make([]byte, saltSize)
+			_, err = rand.Read(e.Salt)
+			if err != nil {
+
+				return err
+			}
+		}
+
+		key, err := deriveKey(shared, e.Salt)
+		if err != nil {
+
+			return err
+		}
+
+		e.Scheme = SchemeSecretBox
+		e.KeyID = []byte(id)
+		if len(id) < 1 {
+
+			e.KeyID = keyID(shared)
+		}
+		e.Data = secretbox.Seal(nil, []byte(msg.Message), &e.Nonce, &key)
+
+	default:
+		return ErrNoKey
+	}
+
+	s, err := e.encode()
 	if err != nil {
 
 		return
 	}
 
-	if len(out) > MessageLimit {
+	if len(s) > MessageLimit {
 
 		return ErrMessageLimit
 	}
 
-	msg.Message = fmt.Sprintf("%s %s", "@Encrypted@", out)
+	msg.Message = s
 	return
 }
 
-func encrypt(key [keySize]byte, in []byte) (out []byte, err error) {
+// Return the secretbox key for Key. Raw keys are used as they are, anything
+// else is stretched with scrypt and salt.
+func deriveKey(s string, salt []byte) (key [keySize]byte, err error) {
+
+	key, raw := rawKey(s)
+	if raw {
+
+		return
+	}
 
-	// Create a new nonce
-	_, nonce, err := newNonce()
+	b, err := scrypt.Key([]byte(s), salt, scryptN, scryptR, scryptP, keySize)
 	if err != nil {
 
 		return
 	}
 
-	// Encrypt
-	out = secretbox.Seal(out, []byte(in), &nonce, &key)
+	copy(key[:], b)
+	return
+}
+
+// Decode a key of exactly 32 bytes written as hex or base64
+func rawKey(s string) (key [keySize]byte, ok bool) {
+
+	for _, decode := range []func(string) ([]byte, error){
+
+		hex.DecodeString,
+		base64.StdEncoding.DecodeString,
+		base64.URLEncoding.DecodeString,
+	} {
+
+		b, err := decode(s)
+		if err == nil && len(b) == keySize {
+
+			copy(key[:], b)
+			return key, true
+		}
+	}
+
+	return
+}
+
+// Generate a random 32 byte key for Key, encoded as base64
+func GenerateKey() (key string, err error) {
+
+	var b [keySize]byte
+	_, err = rand.Read(b[:])
+	if err != nil {
+
+		return
+	}
+
+	return encodeBase64String(b[:])
+}
 
-	// Put the nonce at the front of the array
-	out = append(nonce[:], out...)
+// Return the fingerprint of a raw key sent in its envelopes when it has no
+// id. Passphrases have none.
+func keyID(s string) []byte {
+
+	key, raw := rawKey(s)
+	if !raw {
+
+		return nil
+	}
+
+	sum := sha256.Sum256(key[:])
+	return sum[:keyIDSize]
+}
+
+// Generate a Curve25519 key pair for box encryption. Both keys are encoded
+// as base64, the public key is given to whoever sends to this device.
+func GenerateKeyPair() (publicKey, privateKey string, err error) {
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+
+		return
+	}
+
+	publicKey, err = encodeBase64String(pub[:])
+	if err != nil {
+
+		return
+	}
+
+	privateKey, err = encodeBase64String(priv[:])
+	return
+}
+
+// Return the public key that belongs to a private key
+func PublicKey(privateKey string) (publicKey string, err error) {
+
+	priv, err := decodeKey(privateKey)
+	if err != nil {
+
+		return
+	}
+
+	var pub [keySize]byte
+	curve25519.ScalarBaseMult(&pub, &priv)
+
+	return encodeBase64String(pub[:])
+}
+
+func decodeKey(s string) (key [keySize]byte, err error) {
+
+	b, err := decodeBase64String(s)
+	if err != nil || len(b) != keySize {
+
+		return key, ErrBoxKey
+	}
 
+	copy(key[:], b)
 	return
 }
 
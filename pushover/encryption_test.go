@@ -0,0 +1,467 @@
+package pushover_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+// Return a server that delivers pushed messages back to the device
+func loopback() *pushovertest.Server {
+
+	srv := pushovertest.NewServer()
+	srv.Loopback = true
+	return srv
+}
+
+// Return the body of the last message pushed to the server
+func lastPushed(t *testing.T, srv *pushovertest.Server) string {
+
+	t.Helper()
+
+	pushed := srv.Pushed()
+	if len(pushed) < 1 {
+
+		t.Fatal("server received no messages")
+	}
+
+	return pushed[len(pushed)-1].Get("message")
+}
+
+// Fetch messages and return the newest one, checking that an undecryptable
+// message is marked as such
+func fetchLast(t *testing.T, c *pushover.Client) pushover.PullMessage {
+
+	t.Helper()
+
+	_, err := c.FetchMessages()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	msgs := c.MessagesResponse.Messages
+	if len(msgs) < 1 {
+
+		t.Fatal("no messages fetched")
+	}
+
+	m := msgs[len(msgs)-1]
+	if m.Undecryptable != nil && !strings.HasPrefix(m.Message, pushover.UndecryptableMessage) {
+
+		t.Fatalf("undecryptable message not marked: %q", m.Message)
+	}
+
+	return m
+}
+
+func TestSecretBoxRoundTrip(t *testing.T) {
+
+	srv := loopback()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.Key = "correct horse battery staple"
+
+	msg := pushover.PushMessage{Message: "hello", Title: "Title", Priority: 1, TTL: 60}
+	for i := 0; i < 2; i++ {
+
+		err := c.PushMessage(msg, true)
+		if err != nil {
+
+			t.Fatal(err)
+		}
+	}
+
+	pushed := srv.Pushed()
+	first, second := pushed[0].Get("message"), pushed[1].Get("message")
+	if !strings.HasPrefix(first, "@Sealed@ ") || strings.Contains(first, "hello") {
+
+		t.Fatalf("message was not sealed: %q", first)
+	}
+	if first == second {
+
+		t.Fatal("two messages were sealed the same")
+	}
+
+	r := loggedIn(t, srv)
+	r.Key = c.Key
+
+	m := fetchLast(t, r)
+	if m.Undecryptable != nil || m.Message != "hello" || m.Title != "Title" || m.Priority != 1 || m.TTL != 60 {
+
+		t.Fatalf("got %+v", m)
+	}
+
+	r.Key = "correct horse battery stapler"
+	if m := fetchLast(t, r); m.Undecryptable != pushover.ErrKeyID {
+
+		t.Fatalf("got %v, want ErrKeyID", m.Undecryptable)
+	}
+}
+
+// Raw keys are the same whether written as hex or base64, and are not
+// confused with passphrases
+func TestRawKeyRoundTrip(t *testing.T) {
+
+	srv := loopback()
+	defer srv.Close()
+
+	key, err := pushover.GenerateKey()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	c := srv.Client()
+	c.Key = key
+
+	err = c.PushMessage(pushover.PushMessage{Message: "raw"}, true)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	r := loggedIn(t, srv)
+	r.Key = hex.EncodeToString(b)
+	if m := fetchLast(t, r); m.Undecryptable != nil || m.Message != "raw" {
+
+		t.Fatalf("got %+v", m)
+	}
+
+	r.Key = "passphrase"
+	if m := fetchLast(t, r); m.Undecryptable != pushover.ErrKeyID {
+
+		t.Fatalf("got %v, want ErrKeyID", m.Undecryptable)
+	}
+}
+
+func TestBoxRoundTrip(t *testing.T) {
+
+	srv := loopback()
+	defer srv.Close()
+
+	recipientPub, recipientPriv, err := pushover.GenerateKeyPair()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	senderPub, senderPriv, err := pushover.GenerateKeyPair()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	c := srv.Client()
+	c.PrivateKey = senderPriv
+	c.RecipientKey = recipientPub
+
+	err = c.PushMessage(pushover.PushMessage{Message: "boxed"}, true)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	r := loggedIn(t, srv)
+	r.PrivateKey = recipientPriv
+	r.SenderKeys = []string{senderPub}
+	if m := fetchLast(t, r); m.Undecryptable != nil || m.Message != "boxed" {
+
+		t.Fatalf("got %+v", m)
+	}
+
+	r.SenderKeys = []string{recipientPub}
+	if m := fetchLast(t, r); m.Undecryptable != pushover.ErrSender {
+
+		t.Fatalf("got %v, want ErrSender", m.Undecryptable)
+	}
+
+	r.PrivateKey = senderPriv
+	r.SenderKeys = nil
+	if m := fetchLast(t, r); m.Undecryptable != pushover.ErrBox {
+
+		t.Fatalf("got %v, want ErrBox", m.Undecryptable)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+
+	srv := loopback()
+	defer srv.Close()
+
+	now := time.Now()
+	current := pushover.SharedKey{ID: "current", Key: "current pass", NotBefore: now.Add(-time.Hour)}
+
+	c := srv.Client()
+	c.Keyring = []pushover.SharedKey{
+
+		{ID: "old", Key: "old pass", NotAfter: now.Add(-time.Hour)},
+		current,
+		{ID: "next", Key: "next pass", NotBefore: now.Add(time.Hour)},
+	}
+
+	err := c.PushMessage(pushover.PushMessage{Message: "rotated"}, true)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	r := loggedIn(t, srv)
+	r.Keyring = []pushover.SharedKey{current}
+	if m := fetchLast(t, r); m.Undecryptable != nil || m.Message != "rotated" {
+
+		t.Fatalf("got %+v", m)
+	}
+
+	// A message sealed with a key that has since expired, dated back to when
+	// it was still valid
+	old := pushover.SharedKey{ID: "old", Key: "old pass", NotAfter: now.Add(-2 * time.Hour)}
+	s := srv.Client()
+	s.Keyring = []pushover.SharedKey{{ID: old.ID, Key: old.Key}}
+
+	err = s.PushMessage(pushover.PushMessage{Message: "backdated"}, true)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	backdated := pushover.PullMessage{
+
+		Date:    now.Add(-3 * time.Hour).Unix(),
+		Message: lastPushed(t, srv),
+	}
+
+	r.Keyring = []pushover.SharedKey{current, old}
+	backdated.ID = srv.Highest() + 1
+	srv.QueueMessage(backdated)
+	if m := fetchLast(t, r); m.Undecryptable != pushover.ErrKeyExpired {
+
+		t.Fatalf("got %v, want ErrKeyExpired", m.Undecryptable)
+	}
+
+	// It still opens if received within the grace period
+	r.KeyGrace = 3 * time.Hour
+	backdated.ID = srv.Highest() + 1
+	srv.QueueMessage(backdated)
+	if m := fetchLast(t, r); m.Undecryptable != nil || m.Message != "backdated" {
+
+		t.Fatalf("got %+v", m)
+	}
+}
+
+// A Key that moved into the Keyring still opens the messages sealed with it
+// before it had an id. Passphrases carry no fingerprint and are tried in turn.
+func TestKeyringFingerprint(t *testing.T) {
+
+	srv := loopback()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.Key = "shared pass"
+
+	err := c.PushMessage(pushover.PushMessage{Message: "unnamed"}, true)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(lastPushed(t, srv), "@Sealed@ "))
+	if err != nil || len(b) < 3 || b[2] != 0 {
+
+		t.Fatalf("passphrase envelope has a key id: %x, %v", b, err)
+	}
+
+	r := loggedIn(t, srv)
+	r.Keyring = []pushover.SharedKey{{ID: "other", Key: "other pass"}, {ID: "shared", Key: "shared pass"}}
+	if m := fetchLast(t, r); m.Undecryptable != nil || m.Message != "unnamed" {
+
+		t.Fatalf("got %+v", m)
+	}
+
+	r.Keyring = r.Keyring[:1]
+	if m := fetchLast(t, r); m.Undecryptable != pushover.ErrKeyID {
+
+		t.Fatalf("got %v, want ErrKeyID", m.Undecryptable)
+	}
+}
+
+func TestAppKeys(t *testing.T) {
+
+	srv := loopback()
+	defer srv.Close()
+
+	ciToken := "aciTokenaaaaaaaaaaaaaaaaaaaaaa"
+	monitorToken := "amonitorTokenaaaaaaaaaaaaaaaaa"
+	ci := srv.AddApp(ciToken, "CI")
+	srv.AddApp(monitorToken, "Monitor")
+
+	pushes := []struct {
+		token, key, message string
+	}{
+		{"", "default pass", "from default"},
+		{ciToken, "ci pass", "from ci"},
+		{monitorToken, "ci pass", "from an impostor"},
+		{monitorToken, "monitor pass", "from monitor"},
+	}
+	for _, p := range pushes {
+
+		c := srv.Client()
+		if len(p.token) > 0 {
+
+			c.AppToken = p.token
+		}
+		c.Key = p.key
+
+		err := c.PushMessage(pushover.PushMessage{Message: p.message}, true)
+		if err != nil {
+
+			t.Fatal(err)
+		}
+	}
+
+	r := loggedIn(t, srv)
+	r.Key = "default pass"
+	r.AppKeys = []pushover.AppKey{{Aid: ci, Key: "ci pass"}, {App: "Monitor", Key: "monitor pass"}}
+
+	err := pushover.VerifyAppKeys(r.AppKeys)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	_, err = r.FetchMessages()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	msgs := r.MessagesResponse.Messages
+	if len(msgs) != len(pushes) {
+
+		t.Fatalf("fetched %d messages, want %d", len(msgs), len(pushes))
+	}
+
+	for i, m := range msgs {
+
+		if i == 2 {
+
+			if m.Undecryptable != pushover.ErrKeyID {
+
+				t.Errorf("message %d: got %v, want ErrKeyID", i, m.Undecryptable)
+			}
+			continue
+		}
+		if m.Undecryptable != nil || m.Message != pushes[i].message {
+
+			t.Errorf("message %d: got %+v", i, m)
+		}
+	}
+
+	if pushover.VerifyAppKeys([]pushover.AppKey{{Key: "pass"}}) != pushover.ErrAppKey {
+
+		t.Error("app key without an application was accepted")
+	}
+}
+
+// Return message text for an envelope of raw bytes
+func sealed(b ...[]byte) string {
+
+	var out []byte
+	for _, v := range b {
+
+		out = append(out, v...)
+	}
+
+	return "@Sealed@ " + base64.StdEncoding.EncodeToString(out)
+}
+
+func TestRejectedEnvelopes(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	r := loggedIn(t, srv)
+	r.Key = "pass"
+
+	legacy := base64.StdEncoding.EncodeToString(make([]byte, 60))
+	body := make([]byte, 80)
+	cases := []struct {
+		name    string
+		message string
+		err     error
+	}{
+		{"unversioned secretbox", "@Enc@ " + legacy, pushover.ErrEnvelopeLegacy},
+		{"unversioned encrypted", "@Encrypted@ " + legacy, pushover.ErrEnvelopeLegacy},
+		{"unversioned box", "@Box@ " + legacy, pushover.ErrEnvelopeLegacy},
+		{"not base64", "@Sealed@ !!!", pushover.ErrEnvelope},
+		{"version 0", sealed([]byte{0, 1, 8}, body), pushover.ErrEnvelopeVersion},
+		{"newer version", sealed([]byte{2, 1, 8}, body), pushover.ErrEnvelopeVersion},
+		{"unknown scheme", sealed([]byte{1, 9, 8}, body), pushover.ErrEnvelopeScheme},
+		{"truncated", sealed([]byte{1, 1, 8}, make([]byte, 20)), pushover.ErrEnvelope},
+		{"no key id or salt", sealed([]byte{1, 1, 0, 0}, body), pushover.ErrEnvelope},
+		{"short box key", sealed([]byte{1, 2, 8}, body), pushover.ErrEnvelope},
+		{"bad salt length", sealed([]byte{1, 1, 8}, make([]byte, 8), []byte{5}, body), pushover.ErrEnvelope},
+		{"salted box", sealed([]byte{1, 2, 32}, make([]byte, 32), []byte{16}, body), pushover.ErrEnvelope},
+		{"wrong key", sealed([]byte{1, 1, 8}, make([]byte, 8), []byte{16}, body), pushover.ErrKeyID},
+		{"wrong passphrase", sealed([]byte{1, 1, 0, 16}, body), pushover.ErrKeyID},
+	}
+
+	for i, v := range cases {
+
+		srv.QueueMessage(pushover.PullMessage{ID: i + 1, Message: v.message})
+
+		m := fetchLast(t, r)
+		if m.Undecryptable != v.err {
+
+			t.Errorf("%s: got %v, want %v", v.name, m.Undecryptable, v.err)
+		}
+	}
+}
+
+func TestUnencryptedWithoutKey(t *testing.T) {
+
+	srv := loopback()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.Key = "pass"
+
+	err := c.PushMessage(pushover.PushMessage{Message: "sealed"}, true)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	// Left as it is, there is nothing to decrypt it with
+	r := loggedIn(t, srv)
+	if m := fetchLast(t, r); m.Undecryptable != nil || m.Message != lastPushed(t, srv) {
+
+		t.Fatalf("got %+v", m)
+	}
+}
+
+func TestEncryptedMessageLimit(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.Key = "pass"
+
+	err := c.PushMessage(pushover.PushMessage{Message: strings.Repeat("x", 400)}, true)
+	if err != pushover.ErrMessageLimit {
+
+		t.Fatalf("got %v, want ErrMessageLimit", err)
+	}
+}
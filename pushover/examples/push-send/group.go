@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+const groupUsage = `  group list                              List the groups of the application
+  group create <name>                     Create a group and print its key
+  group show <group>                      Print the members of a group
+  group rename <group> <name>             Rename a group
+  group add <group> <user> [device] [memo]
+                                          Add a member to a group
+  group remove <group> <user> [device]    Remove a member from a group
+  group enable <group> <user> [device]    Resume delivery to a member
+  group disable <group> <user> [device]   Stop delivery to a member`
+
+// Run a group subcommand. Exits on error so scripts can check the status.
+func groupCommand(client *pushover.Client, args []string) {
+
+	ctx := context.Background()
+
+	arg := func(i int) string {
+
+		if i < len(args) {
+
+			return args[i]
+		}
+		return ""
+	}
+
+	var err error
+	switch {
+
+	case arg(0) == "list":
+		var groups []pushover.Group
+		groups, err = client.ListGroupsContext(ctx)
+		for _, g := range groups {
+
+			fmt.Printf("%s\t%s\n", g.Key, g.Name)
+		}
+
+	case arg(0) == "create" && len(args) == 2:
+		var key string
+		key, err = client.CreateGroupContext(ctx, arg(1))
+		if err == nil {
+
+			fmt.Println(key)
+		}
+
+	case arg(0) == "show" && len(args) == 2:
+		var g pushover.Group
+		g, err = client.GetGroupContext(ctx, arg(1))
+		if err == nil {
+
+			fmt.Printf("%s\t%s\n", g.Key, g.Name)
+			for _, u := range g.Users {
+
+				state := "enabled"
+				if u.Disabled {
+
+					state = "disabled"
+				}
+				fmt.Printf("  %s\t%s\t%s\t%s\n", u.User, u.Device, state, u.Memo)
+			}
+		}
+
+	case arg(0) == "rename" && len(args) == 3:
+		_, err = client.RenameGroupContext(ctx, arg(1), arg(2))
+
+	case arg(0) == "add" && len(args) >= 3 && len(args) <= 5:
+		u := pushover.GroupUser{User: arg(2), Device: arg(3), Memo: arg(4)}
+		_, err = client.AddGroupUserContext(ctx, arg(1), u)
+
+	case arg(0) == "remove" && (len(args) == 3 || len(args) == 4):
+		_, err = client.RemoveGroupUserContext(ctx, arg(1), arg(2), arg(3))
+
+	case arg(0) == "enable" && (len(args) == 3 || len(args) == 4):
+		_, err = client.EnableGroupUserContext(ctx, arg(1), arg(2), arg(3))
+
+	case arg(0) == "disable" && (len(args) == 3 || len(args) == 4):
+		_, err = client.DisableGroupUserContext(ctx, arg(1), arg(2), arg(3))
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+
+		log.Fatalf("group %s: %s\n", arg(0), err)
+	}
+}
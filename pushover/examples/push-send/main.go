@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/TheCreeper/OpenPushOver/pushover"
@@ -12,7 +17,16 @@ var (
 	apptoken string
 	userkey  string
 
-	key string
+	key        string
+	keyid      string
+	privatekey string
+	recipient  string
+	retries    int
+	limits     bool
+	reserve    int
+
+	validate bool
+	device   string
 
 	title    string
 	message  string
@@ -22,13 +36,26 @@ var (
 	url      string
 	urltitle string
 	callback string
+
+	attachment string
+	html       bool
+	monospace  bool
+	ttl        int
 )
 
 func init() {
 
 	flag.StringVar(&apptoken, "apptoken", "", "")
 	flag.StringVar(&userkey, "userkey", "", "")
-	flag.StringVar(&key, "key", "", "")
+	flag.StringVar(&key, "key", "", "Shared key to encrypt the message with, see push keygen")
+	flag.StringVar(&keyid, "key-id", "", "Id of -key in the keyring of the recipient")
+	flag.StringVar(&privatekey, "private-key", "", "Private key to box the message with, see keypair")
+	flag.StringVar(&recipient, "recipient", "", "Public key of the device to box the message for")
+	flag.IntVar(&retries, "retries", pushover.DefaultRetryPolicy.MaxAttempts, "Attempts made when the API is briefly unavailable")
+	flag.BoolVar(&limits, "limits", false, "Print the application message limits and exit")
+	flag.IntVar(&reserve, "reserve", 0, "Refuse to send when no more than this many messages are left this month")
+	flag.BoolVar(&validate, "validate", false, "Check the user key and device name with the API before sending")
+	flag.StringVar(&device, "device", "", "Send to this device name only")
 
 	flag.StringVar(&title, "title", "", "")
 	flag.StringVar(&message, "message", "", "")
@@ -38,20 +65,96 @@ func init() {
 	flag.StringVar(&url, "url", "", "")
 	flag.StringVar(&urltitle, "url-title", "", "")
 	flag.StringVar(&callback, "callback", "", "")
+	flag.StringVar(&attachment, "attachment", "", "Image file to attach to the message")
+	flag.BoolVar(&html, "html", false, "Format the message with the Pushover HTML subset")
+	flag.BoolVar(&monospace, "monospace", false, "Show the message in a monospace font")
+	flag.IntVar(&ttl, "ttl", 0, "Seconds until the message is removed from devices")
+	flag.Usage = func() {
+
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [command]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "%s\n", groupUsage)
+		fmt.Fprintf(os.Stderr, "%s\n\n", keypairUsage)
+		fmt.Fprintf(os.Stderr, "Without a command the message is sent.\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 }
 
 func main() {
 
-	client := pushover.Client{
+	policy := pushover.DefaultRetryPolicy
+	policy.MaxAttempts = retries
+
+	client := &pushover.Client{
+
+		AppToken:     apptoken,
+		UserKey:      userkey,
+		Key:          key,
+		PrivateKey:   privatekey,
+		RecipientKey: recipient,
+		Retry:        &policy,
+	}
+	if len(keyid) > 0 {
+
+		client.Key = ""
+		client.Keyring = []pushover.SharedKey{{ID: keyid, Key: key}}
+	}
+
+	switch flag.Arg(0) {
+
+	case "":
+
+	case "group":
+		groupCommand(client, flag.Args()[1:])
+		return
+
+	case "keypair":
+		pub, priv, err := pushover.GenerateKeyPair()
+		if err != nil {
+
+			log.Fatalf("GenerateKeyPair: %s\n", err)
+		}
+		fmt.Printf("Public:  %s\nPrivate: %s\n", pub, priv)
+		return
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if limits || reserve > 0 {
+
+		client.Limiter = &pushover.Limiter{Reserve: reserve}
 
-		AppToken: apptoken,
-		UserKey:  userkey,
-		Key:      key,
+		l, err := client.AppLimitsContext(context.Background())
+		if err != nil {
+
+			log.Fatalf("AppLimits: %s\n", err)
+		}
+		if limits {
+
+			printLimits(l)
+			return
+		}
+	}
+
+	if validate {
+
+		v, err := client.ValidateUserContext(context.Background(), userkey, device)
+		if err != nil {
+
+			log.Fatalf("ValidateUser: %s\n", err)
+		}
+		if !v.Valid {
+
+			log.Fatalf("ValidateUser: %s\n", strings.Join(v.Errors, ", "))
+		}
 	}
 
 	message := pushover.PushMessage{
 
+		Device:    device,
 		Title:     title,
 		Message:   message,
 		Priority:  priority,
@@ -60,23 +163,49 @@ func main() {
 		UrlTitle:  urltitle,
 		Sound:     sound,
 		Callback:  callback,
+		Html:      html,
+		Monospace: monospace,
+		TTL:       ttl,
 		Timestamp: int64(time.Now().Unix()),
 	}
 
+	if len(attachment) > 0 {
+
+		f, err := os.Open(attachment)
+		if err != nil {
+
+			log.Fatalf("Attachment: %s\n", err)
+		}
+		defer f.Close()
+
+		message.Attachment = &pushover.Attachment{
+
+			Reader: f,
+			Name:   filepath.Base(attachment),
+		}
+	}
+
 	var encrypt = false
-	if len(key) > 1 {
+	if len(key) > 1 || len(recipient) > 0 {
 
 		encrypt = true
 	}
 
-	err := client.PushMessage(message, encrypt)
+	resp, err := client.PushMessageContext(context.Background(), message, encrypt)
 	if err != nil {
 
 		log.Fatalf("Push: %s\n", err)
 	}
 
 	log.Printf("Message Sent\n")
-	log.Printf("AppLimit Messages: %s\n", client.Accounting.AppLimit)
-	log.Printf("AppLimit Remaining Messages: %s\n", client.Accounting.AppRemaining)
-	log.Printf("AppLimit Time to Reset: %s\n", client.Accounting.AppReset)
+	printLimits(resp.Limits)
+}
+
+const keypairUsage = `  keypair                                 Print a key pair for -private-key`
+
+func printLimits(l pushover.Limits) {
+
+	log.Printf("AppLimit Messages: %d\n", l.Limit)
+	log.Printf("AppLimit Remaining Messages: %d\n", l.Remaining)
+	log.Printf("AppLimit Time to Reset: %s\n", l.Reset.Format(time.RFC1123))
 }
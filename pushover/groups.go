@@ -0,0 +1,287 @@
+package pushover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Errors
+var (
+	ErrCreateGroup      = errors.New("Unable to create group")
+	ErrGetGroup         = errors.New("Unable to get group")
+	ErrListGroups       = errors.New("Unable to list groups")
+	ErrAddGroupUser     = errors.New("Unable to add user to group")
+	ErrRemoveGroupUser  = errors.New("Unable to remove user from group")
+	ErrEnableGroupUser  = errors.New("Unable to enable group user")
+	ErrDisableGroupUser = errors.New("Unable to disable group user")
+	ErrRenameGroup      = errors.New("Unable to rename group")
+	ErrVerifyGroupName  = fmt.Errorf("Group name must contain between 1 and %d characters", GroupNameLimit)
+	ErrGroupMemoLimit   = fmt.Errorf("Group memo is over the %d char limit", GroupMemoLimit)
+)
+
+// A delivery group. Messages sent to the group key go to every enabled member.
+type Group struct {
+	Key   string      `json:"group"`
+	Name  string      `json:"name"`
+	Users []GroupUser `json:"users"`
+}
+
+// A member of a delivery group
+type GroupUser struct {
+	User     string `json:"user"`     // User key of the member
+	Device   string `json:"device"`   // Limit delivery to this device, empty for all
+	Memo     string `json:"memo"`     // Free text note, such as the name of the member
+	Disabled bool   `json:"disabled"` // Member is kept but receives nothing
+}
+
+type GroupResponse struct {
+	Group
+	Groups []Group `json:"groups"`
+
+	Status  int      `json:"status"`
+	Request string   `json:"request"`
+	Errors  []string `json:"errors"`
+}
+
+func verifyGroupName(name string) error {
+
+	if (len(name) < 1) || (len(name) > GroupNameLimit) {
+
+		return ErrVerifyGroupName
+	}
+
+	return nil
+}
+
+// Send a request to groups/{key}/{action}.json
+func (c *Client) groupCall(ctx context.Context, key, action string, vars url.Values, op error) (resp GroupResponse, err error) {
+
+	err = VerifyAppToken(c.AppToken)
+	if err != nil {
+
+		return
+	}
+
+	err = VerifyUserKey(key)
+	if err != nil {
+
+		return
+	}
+
+	vars.Add("token", c.AppToken)
+
+	urlF := fmt.Sprintf("%s/groups/%s/%s.json", c.baseUrl(), key, action)
+	_, err = c.doJSON(ctx, "POST", urlF, vars, op, &resp)
+	return
+}
+
+// Send a request about one member of a group
+func (c *Client) groupUserCall(ctx context.Context, key, action, user, device string, op error) (resp GroupResponse, err error) {
+
+	err = VerifyUserKey(user)
+	if err != nil {
+
+		return
+	}
+
+	err = VerifyDeviceName(device)
+	if err != nil {
+
+		return
+	}
+
+	vars := url.Values{}
+	vars.Add("user", user)
+	if len(device) > 0 {
+
+		vars.Add("device", device)
+	}
+
+	return c.groupCall(ctx, key, action, vars, op)
+}
+
+// Create an empty group and return its key
+func (c *Client) CreateGroup(name string) (key string, err error) {
+
+	return c.CreateGroupContext(context.Background(), name)
+}
+
+// CreateGroupContext is like CreateGroup but uses ctx for cancellation and deadlines
+func (c *Client) CreateGroupContext(ctx context.Context, name string) (key string, err error) {
+
+	err = VerifyAppToken(c.AppToken)
+	if err != nil {
+
+		return
+	}
+
+	err = verifyGroupName(name)
+	if err != nil {
+
+		return
+	}
+
+	vars := url.Values{}
+	vars.Add("token", c.AppToken)
+	vars.Add("name", name)
+
+	var resp GroupResponse
+	urlF := fmt.Sprintf("%s%s", c.baseUrl(), "/groups.json")
+	_, err = c.doJSON(ctx, "POST", urlF, vars, ErrCreateGroup, &resp)
+	return resp.Key, err
+}
+
+// Return the name and members of a group
+func (c *Client) GetGroup(key string) (Group, error) {
+
+	return c.GetGroupContext(context.Background(), key)
+}
+
+// GetGroupContext is like GetGroup but uses ctx for cancellation and deadlines
+func (c *Client) GetGroupContext(ctx context.Context, key string) (g Group, err error) {
+
+	err = VerifyAppToken(c.AppToken)
+	if err != nil {
+
+		return
+	}
+
+	err = VerifyUserKey(key)
+	if err != nil {
+
+		return
+	}
+
+	var resp GroupResponse
+	urlF := fmt.Sprintf("%s/groups/%s.json?token=%s", c.baseUrl(), key, c.AppToken)
+	_, err = c.doJSON(ctx, "GET", urlF, nil, ErrGetGroup, &resp)
+	if err != nil {
+
+		return
+	}
+
+	g = resp.Group
+	g.Key = key
+	return
+}
+
+// Return the key and name of every group owned by the application. Members
+// are not included, use GetGroup for those.
+func (c *Client) ListGroups() ([]Group, error) {
+
+	return c.ListGroupsContext(context.Background())
+}
+
+// ListGroupsContext is like ListGroups but uses ctx for cancellation and deadlines
+func (c *Client) ListGroupsContext(ctx context.Context) (groups []Group, err error) {
+
+	err = VerifyAppToken(c.AppToken)
+	if err != nil {
+
+		return
+	}
+
+	var resp GroupResponse
+	urlF := fmt.Sprintf("%s/groups.json?token=%s", c.baseUrl(), c.AppToken)
+	_, err = c.doJSON(ctx, "GET", urlF, nil, ErrListGroups, &resp)
+	return resp.Groups, err
+}
+
+// Add a member to a group. Disabled is ignored, new members are enabled.
+func (c *Client) AddGroupUser(key string, u GroupUser) (GroupResponse, error) {
+
+	return c.AddGroupUserContext(context.Background(), key, u)
+}
+
+// AddGroupUserContext is like AddGroupUser but uses ctx for cancellation and deadlines
+func (c *Client) AddGroupUserContext(ctx context.Context, key string, u GroupUser) (resp GroupResponse, err error) {
+
+	err = VerifyUserKey(u.User)
+	if err != nil {
+
+		return
+	}
+
+	err = VerifyDeviceName(u.Device)
+	if err != nil {
+
+		return
+	}
+
+	if len(u.Memo) > GroupMemoLimit {
+
+		err = ErrGroupMemoLimit
+		return
+	}
+
+	vars := url.Values{}
+	vars.Add("user", u.User)
+	if len(u.Device) > 0 {
+
+		vars.Add("device", u.Device)
+	}
+	if len(u.Memo) > 0 {
+
+		vars.Add("memo", u.Memo)
+	}
+
+	return c.groupCall(ctx, key, "add_user", vars, ErrAddGroupUser)
+}
+
+// Remove a member from a group. Pass the device the member was added with.
+func (c *Client) RemoveGroupUser(key, user, device string) (GroupResponse, error) {
+
+	return c.RemoveGroupUserContext(context.Background(), key, user, device)
+}
+
+// RemoveGroupUserContext is like RemoveGroupUser but uses ctx for cancellation and deadlines
+func (c *Client) RemoveGroupUserContext(ctx context.Context, key, user, device string) (GroupResponse, error) {
+
+	return c.groupUserCall(ctx, key, "remove_user", user, device, ErrRemoveGroupUser)
+}
+
+// Resume delivery to a disabled member of a group
+func (c *Client) EnableGroupUser(key, user, device string) (GroupResponse, error) {
+
+	return c.EnableGroupUserContext(context.Background(), key, user, device)
+}
+
+// EnableGroupUserContext is like EnableGroupUser but uses ctx for cancellation and deadlines
+func (c *Client) EnableGroupUserContext(ctx context.Context, key, user, device string) (GroupResponse, error) {
+
+	return c.groupUserCall(ctx, key, "enable_user", user, device, ErrEnableGroupUser)
+}
+
+// Stop delivery to a member of a group without removing it
+func (c *Client) DisableGroupUser(key, user, device string) (GroupResponse, error) {
+
+	return c.DisableGroupUserContext(context.Background(), key, user, device)
+}
+
+// DisableGroupUserContext is like DisableGroupUser but uses ctx for cancellation and deadlines
+func (c *Client) DisableGroupUserContext(ctx context.Context, key, user, device string) (GroupResponse, error) {
+
+	return c.groupUserCall(ctx, key, "disable_user", user, device, ErrDisableGroupUser)
+}
+
+func (c *Client) RenameGroup(key, name string) (GroupResponse, error) {
+
+	return c.RenameGroupContext(context.Background(), key, name)
+}
+
+// RenameGroupContext is like RenameGroup but uses ctx for cancellation and deadlines
+func (c *Client) RenameGroupContext(ctx context.Context, key, name string) (resp GroupResponse, err error) {
+
+	err = verifyGroupName(name)
+	if err != nil {
+
+		return
+	}
+
+	vars := url.Values{}
+	vars.Add("name", name)
+
+	return c.groupCall(ctx, key, "rename", vars, ErrRenameGroup)
+}
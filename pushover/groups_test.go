@@ -0,0 +1,107 @@
+package pushover_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+func TestGroups(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+
+	key, err := c.CreateGroup("oncall")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	_, err = c.AddGroupUser(key, pushover.GroupUser{User: srv.UserKey, Memo: "me"})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	_, err = c.AddGroupUser(key, pushover.GroupUser{User: srv.UserKey})
+	if !errors.Is(err, pushover.ErrAddGroupUser) {
+
+		t.Fatalf("added a member twice: %v", err)
+	}
+
+	_, err = c.DisableGroupUser(key, srv.UserKey, "")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	_, err = c.RenameGroup(key, "rota")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	g, err := c.GetGroup(key)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if g.Key != key || g.Name != "rota" || len(g.Users) != 1 || !g.Users[0].Disabled || g.Users[0].Memo != "me" {
+
+		t.Fatalf("got %+v", g)
+	}
+
+	_, err = c.EnableGroupUser(key, srv.UserKey, "")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if g, _ := srv.Group(key); g.Users[0].Disabled {
+
+		t.Fatal("member still disabled")
+	}
+
+	groups, err := c.ListGroups()
+	if err != nil || len(groups) != 1 || groups[0].Key != key {
+
+		t.Fatalf("got %+v, %v", groups, err)
+	}
+
+	v, err := c.ValidateUser(key, "")
+	if err != nil || !v.Valid || !v.Group {
+
+		t.Fatalf("got %+v, %v", v, err)
+	}
+
+	// Group keys are pushed to like user keys
+	c.UserKey = key
+	err = c.Push("hello")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if pushed := srv.Pushed(); len(pushed) != 1 || pushed[0].Get("user") != key {
+
+		t.Fatalf("server received %v", pushed)
+	}
+
+	_, err = c.RemoveGroupUser(key, srv.UserKey, "")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if g, _ := srv.Group(key); len(g.Users) != 0 {
+
+		t.Fatalf("group still has %d members", len(g.Users))
+	}
+
+	_, err = c.GetGroup("g00000000000000000000000000099")
+	if !errors.Is(err, pushover.ErrGetGroup) {
+
+		t.Fatalf("got %v, want ErrGetGroup", err)
+	}
+}
@@ -0,0 +1,193 @@
+package pushover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrQuotaExhausted = errors.New("Monthly message limit reached")
+)
+
+// Monthly message limits of an application
+type Limits struct {
+	Limit     int       // Messages allowed per month
+	Remaining int       // Messages left until Reset
+	Reset     time.Time // When Remaining goes back to Limit
+}
+
+type LimitsResponse struct {
+	Limit     int   `json:"limit"`
+	Remaining int   `json:"remaining"`
+	Reset     int64 `json:"reset"`
+
+	Status  int    `json:"status"`
+	Request string `json:"request"`
+}
+
+// Parse the X-Limit-App headers sent with every pushed message
+func parseLimits(h http.Header) (l Limits, ok bool) {
+
+	limit, err := strconv.Atoi(h.Get("X-Limit-App-Limit"))
+	if err != nil {
+
+		return
+	}
+
+	remaining, err := strconv.Atoi(h.Get("X-Limit-App-Remaining"))
+	if err != nil {
+
+		return
+	}
+
+	reset, err := strconv.ParseInt(h.Get("X-Limit-App-Reset"), 10, 64)
+	if err != nil {
+
+		return
+	}
+
+	return Limits{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}, true
+}
+
+// Get the message limits of the application from apps/limits.json
+func (c *Client) AppLimits() (Limits, error) {
+
+	return c.AppLimitsContext(context.Background())
+}
+
+// AppLimitsContext is like AppLimits but uses ctx for cancellation and deadlines
+func (c *Client) AppLimitsContext(ctx context.Context) (l Limits, err error) {
+
+	err = VerifyAppToken(c.AppToken)
+	if err != nil {
+
+		return
+	}
+
+	var resp LimitsResponse
+	urlF := fmt.Sprintf("%s/apps/limits.json?token=%s", c.baseUrl(), c.AppToken)
+	_, err = c.doJSON(ctx, "GET", urlF, nil, ErrAppLimits, &resp)
+	if err != nil {
+
+		return
+	}
+
+	l = Limits{Limit: resp.Limit, Remaining: resp.Remaining, Reset: time.Unix(resp.Reset, 0)}
+	if c.Limiter != nil {
+
+		c.Limiter.Update(l)
+	}
+
+	return
+}
+
+// Stops pushing before the monthly limit is used up. Once Remaining falls
+// to Reserve, Wait either fails with ErrQuotaExhausted or, with Block set,
+// waits for the limits to reset. A Limiter may be shared by several Clients
+// using the same application token.
+type Limiter struct {
+	Reserve int  // Messages kept in hand for anything not using the limiter
+	Block   bool // Wait for the reset instead of failing fast
+
+	mu     sync.Mutex
+	limits Limits
+	known  bool
+}
+
+// Set the limits, usually from a push response or AppLimits
+func (l *Limiter) Update(limits Limits) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limits = limits
+	l.known = true
+}
+
+// Return the last known limits
+func (l *Limiter) Limits() (limits Limits, known bool) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limits, l.known
+}
+
+// Record that the API refused a message for being over the limit
+func (l *Limiter) exhausted() {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limits.Remaining = 0
+	if !l.known || l.limits.Reset.Before(time.Now()) {
+
+		// Limits reset at the start of each month
+		now := time.Now().UTC()
+		l.limits.Reset = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	l.known = true
+}
+
+// Take one message from the remaining limit. Until limits are known every
+// message is let through.
+func (l *Limiter) Wait(ctx context.Context) error {
+
+	_, err := l.take(ctx)
+	return err
+}
+
+// Wait, reporting whether a message was taken from Remaining
+func (l *Limiter) take(ctx context.Context) (taken bool, err error) {
+
+	for {
+
+		l.mu.Lock()
+		if !l.known || time.Now().After(l.limits.Reset) {
+
+			l.known = false
+			l.mu.Unlock()
+			return false, nil
+		}
+		if l.limits.Remaining > l.Reserve {
+
+			l.limits.Remaining--
+			l.mu.Unlock()
+			return true, nil
+		}
+		reset := l.limits.Reset
+		l.mu.Unlock()
+
+		if !l.Block {
+
+			return false, ErrQuotaExhausted
+		}
+
+		t := time.NewTimer(time.Until(reset))
+		select {
+
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return false, ctx.Err()
+		}
+	}
+}
+
+// Give back a message taken by take that was never sent
+func (l *Limiter) refund() {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.known && l.limits.Remaining < l.limits.Limit {
+
+		l.limits.Remaining++
+	}
+}
@@ -0,0 +1,104 @@
+package pushover_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+// Pushing stops once Remaining falls to Reserve
+func TestLimiterReserve(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	srv.AppLimit = 5
+
+	c := srv.Client()
+	c.Limiter = &pushover.Limiter{Reserve: 2}
+
+	l, err := c.AppLimits()
+	if err != nil || l.Remaining != 5 {
+
+		t.Fatalf("got %+v, %v", l, err)
+	}
+
+	for i := 0; i < 3; i++ {
+
+		err = c.Push("hello")
+		if err != nil {
+
+			t.Fatal(err)
+		}
+	}
+
+	err = c.Push("hello")
+	if !errors.Is(err, pushover.ErrQuotaExhausted) {
+
+		t.Fatalf("got %v, want ErrQuotaExhausted", err)
+	}
+	if n := len(srv.Pushed()); n != 3 {
+
+		t.Fatalf("server received %d messages, want 3", n)
+	}
+}
+
+func TestLimiterBlock(t *testing.T) {
+
+	l := &pushover.Limiter{Block: true}
+	l.Update(pushover.Limits{Limit: 10, Reset: time.Now().Add(time.Hour)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := l.Wait(ctx)
+	if err != context.DeadlineExceeded {
+
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+
+	// Blocks until the limits reset
+	reset := time.Now().Add(100 * time.Millisecond)
+	l.Update(pushover.Limits{Limit: 10, Reset: reset})
+
+	err = l.Wait(context.Background())
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if time.Now().Before(reset) {
+
+		t.Fatal("Wait returned before the reset")
+	}
+}
+
+// A push that never reached the server does not use up the limit
+func TestLimiterRefund(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.Limiter = &pushover.Limiter{}
+	c.Limiter.Update(pushover.Limits{Limit: 10, Remaining: 5, Reset: time.Now().Add(time.Hour)})
+	c.Dial = func(network, addr string) (net.Conn, error) {
+
+		return nil, errors.New("general SOCKS server failure")
+	}
+
+	err := c.Push("hello")
+	if err == nil {
+
+		t.Fatal("push without a connection succeeded")
+	}
+
+	if l, _ := c.Limiter.Limits(); l.Remaining != 5 {
+
+		t.Fatalf("remaining is %d after a failed dial, want 5", l.Remaining)
+	}
+}
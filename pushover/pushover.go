@@ -6,15 +6,15 @@
 	Reference Implementation: github.com/AlekSi/pushover
 
 	TODO:
-		- Improve encryption with public/private keys
-		- Account for errors returned by the API
 		- Fix message priority not being parsed by fetchmessages
-		- Add disable device
 */
 
 package pushover
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,8 +23,12 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Default API endpoints. They can be overridden per Client.
 const (
 	BaseUrl   = "https://api.pushover.net/1"
 	ClientUrl = "https://client.pushover.net"
@@ -40,7 +44,8 @@ const (
 )
 
 // Message sound
-// Should be used for speciying sounds before sending messages
+// Should be used for speciying sounds before sending messages. See
+// ListSounds for every sound available to an application.
 const (
 	PushoverSound     = "pushover"
 	BikeSound         = "bike"
@@ -72,12 +77,15 @@ var (
 	ErrFetchSound     = errors.New("Unable to fetch sound file")
 	ErrFetchInvalid   = errors.New("Invalid sound name specified")
 	ErrFetchImage     = errors.New("Unable to fetch image")
+	ErrFetchAttach    = errors.New("Unable to fetch attachment")
 	ErrLoginFailed    = errors.New("Failed to login")
 	ErrDeviceRegister = errors.New("Device register failed")
 	ErrPullMsg        = errors.New("Unable to fetch new messages")
 	ErrMarkRead       = errors.New("Markread messages failed")
 	ErrPushMsg        = errors.New("Unable to push message")
 	ErrReceipt        = errors.New("Unable to get receipt")
+	ErrAcknowledge    = errors.New("Unable to acknowledge message")
+	ErrAppLimits      = errors.New("Unable to get application limits")
 	ErrDeviceAuth     = errors.New("Device not authenticated")
 	ErrUserPassword   = errors.New("User Password not specified")
 	ErrUserName       = errors.New("UserName not specified")
@@ -95,40 +103,122 @@ func (e *PushRespErr) Error() string {
 	return e.Query + ": " + e.Err.Error()
 }
 
+func (e *PushRespErr) Unwrap() error {
+
+	return e.Err
+}
+
+// A Client is safe for concurrent use by multiple goroutines once its
+// configuration fields are set. The Context methods return their results;
+// the older methods also store them in the response fields under a lock,
+// so those fields should only be read while no calls are in flight. The
+// same goes for DeviceName and DeviceUUID, which RenameDevice,
+// RegisterDevice and DeleteDevice update.
+//
+// A Client holds a lock and a lazily built HTTP client, so it must not be
+// copied after first use. Share a *Client instead, or build a new one from
+// the same configuration.
 type Client struct {
+	mu sync.Mutex
+
 	Dial func(network, addr string) (net.Conn, error)
 
-	UserName     string // Username
-	UserPassword string // User password
+	HTTPClient *http.Client      // Used for every API request when set, instead of Timeout and TLSConfig
+	Transport  http.RoundTripper // Used when HTTPClient is not set
+	TLSConfig  *tls.Config       // TLS settings for the default transport and the stream
+	Timeout    time.Duration     // Time limit for each API request, including reading the body
+	Retry      *RetryPolicy      // Retries transient failures when set
+	Limiter    *Limiter          // Stops pushing before the monthly limit is reached when set
+
+	once      sync.Once
+	transport *http.Client
+
+	sounds   map[string]string // Cached by ListSounds
+	soundsAt time.Time
+
+	BaseUrl   string // Overrides the BaseUrl constant when set
+	ClientUrl string // Overrides the ClientUrl constant when set
+	StreamUrl string // Overrides the StreamUrl constant when set
+
+	UserName      string // Username
+	UserPassword  string // User password
+	TwoFactorCode string // Sent with the login of accounts using two-factor authentication
 
 	DeviceName string // Device name
 	DeviceUUID string // Device UUID
-	deviceOS   string // Device OS. Should only be single chars such as A (Android), F (Firefox), C (Chrome), or O (Open Client)
 
-	Key string // Key to use for message encryption and decryption
+	Key      string        // Key to use for message encryption and decryption
+	Keyring  []SharedKey   // Keys by id, for rotating keys without losing messages
+	AppKeys  []AppKey      // Keys of applications that don't share Key, checked first
+	KeyGrace time.Duration // Allowed either side of the validity window of a key, DefaultKeyGrace when zero
+
+	PrivateKey   string   // Base64 Curve25519 key, opens boxed messages and seals sent ones
+	RecipientKey string   // Base64 public key of the device to box pushed messages for
+	SenderKeys   []string // Public keys allowed to box messages for this device, any when empty
 
 	Login            Login
 	RegisterResponse RegisterResponse
 	MessagesResponse MessagesResponse
 	MarkReadResponse MarkReadResponse
 
+	AcknowledgeResponse AcknowledgeResponse
+	ReceiptResponse     Receipt
+
 	AppToken string // Application Token
 	UserKey  string // User Key
 
-	Accounting struct {
-		AppLimit     string
-		AppRemaining string
-		AppReset     string
-	}
-
+	Accounting   Accounting
+	Limits       Limits
 	PushResponse PushResponse
 }
 
-func (c *Client) dial(network, addr string) (net.Conn, error) {
+// Device OS sent when registering. Should only be single chars such as A
+// (Android), F (Firefox), C (Chrome), or O (Open Client).
+const (
+	deviceOS = "O"
+)
+
+// Return the device secret and UUID of the current session
+func (c *Client) session() (secret, device string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Login.Secret, c.DeviceUUID
+}
+
+// Return the device name, which RenameDevice may change while in use
+func (c *Client) deviceName() string {
 
-	if c.Dial != nil {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-		return c.Dial(network, addr)
+	return c.DeviceName
+}
+
+// Return the device secret of the last login, to be saved for SetSecret
+func (c *Client) Secret() string {
+
+	secret, _ := c.session()
+	return secret
+}
+
+// Resume a session with a secret saved from an earlier login, so the
+// password is only needed again once the API reports ErrSecretExpired
+func (c *Client) SetSecret(secret string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Login.Secret = secret
+}
+
+// Dial through dial, or directly when it is nil
+func dialWith(dial func(network, addr string) (net.Conn, error), network, addr string) (net.Conn, error) {
+
+	if dial != nil {
+
+		return dial(network, addr)
 	}
 
 	dialer := &net.Dialer{
@@ -139,259 +229,430 @@ func (c *Client) dial(network, addr string) (net.Conn, error) {
 	return dialer.Dial(network, addr)
 }
 
-// Pass the sound name to fetch the apropiate sound
-func (c *Client) FetchSound(sound string) (body []byte, err error) {
+func (c *Client) baseUrl() string {
+
+	if len(c.BaseUrl) > 0 {
+
+		return strings.TrimRight(c.BaseUrl, "/")
+	}
+
+	return BaseUrl
+}
+
+func (c *Client) clientUrl() string {
 
-	urlF := fmt.Sprintf("%s/sounds/%s.wav", ClientUrl, sound)
-	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
-	resp, err := httpClient.Get(urlF)
+	if len(c.ClientUrl) > 0 {
+
+		return strings.TrimRight(c.ClientUrl, "/")
+	}
+
+	return ClientUrl
+}
+
+// Send a request and read the response body, retrying transient failures
+// according to the Retry policy. POST requests with files are sent as
+// multipart/form-data. Error responses are returned as an *APIError for
+// the call op, wrapped in a *PushRespErr.
+func (c *Client) do(ctx context.Context, method, urlF string, vars url.Values, op error, files ...formFile) (resp *http.Response, body []byte, err error) {
+
+	idempotent := method == "GET" || idempotentOps[op]
+
+	for attempt := 1; ; attempt++ {
+
+		resp, body, err = c.send(ctx, method, urlF, vars, op, files...)
+		if err == nil || c.Retry == nil {
+
+			return
+		}
+
+		wait, ok := c.Retry.next(attempt, err, idempotent)
+		if !ok {
+
+			return
+		}
+
+		t := time.NewTimer(wait)
+		select {
+
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// Send a single request and read the response body
+func (c *Client) send(ctx context.Context, method, urlF string, vars url.Values, op error, files ...formFile) (resp *http.Response, body []byte, err error) {
+
+	var req *http.Request
+	if method == "POST" && len(files) > 0 {
+
+		var buf *bytes.Buffer
+		var contentType string
+		buf, contentType, err = multipartBody(vars, files)
+		if err == nil {
+
+			req, err = http.NewRequestWithContext(ctx, method, urlF, buf)
+		}
+		if err == nil {
+
+			req.Header.Set("Content-Type", contentType)
+		}
+	} else if method == "POST" {
+
+		req, err = http.NewRequestWithContext(ctx, method, urlF, strings.NewReader(vars.Encode()))
+		if err == nil {
+
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+
+		req, err = http.NewRequestWithContext(ctx, method, urlF, nil)
+	}
 	if err != nil {
 
-		err = &PushRespErr{Query: urlF, Err: err}
-		return
+		return nil, nil, &PushRespErr{Query: urlF, Err: err}
 	}
-	if resp.StatusCode >= 400 {
 
-		err = &PushRespErr{Query: urlF, Err: ErrFetchSound}
-		return
+	resp, err = c.httpClient().Do(req)
+	if err != nil {
+
+		return nil, nil, &PushRespErr{Query: urlF, Err: err}
 	}
 	defer resp.Body.Close()
 
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
 
-		err = &PushRespErr{Query: urlF, Err: err}
-		return
+		return nil, nil, &PushRespErr{Query: urlF, Err: err}
+	}
+
+	if resp.StatusCode >= 400 {
+
+		apiErr := newAPIError(op, resp.StatusCode, body)
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		return nil, nil, &PushRespErr{Query: urlF, Err: apiErr}
 	}
 
 	return
 }
 
-// Pass the icon id to fetch the apropiate image
-func (c *Client) FetchImage(icon string) (body []byte, err error) {
+// Send a request and decode the JSON response into v. A response with a
+// status other than 1 is an error even when the HTTP status is not.
+func (c *Client) doJSON(ctx context.Context, method, urlF string, vars url.Values, op error, v interface{}, files ...formFile) (resp *http.Response, err error) {
 
-	urlF := fmt.Sprintf("%s/icons/%s.png", ClientUrl, icon)
-	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
-	resp, err := httpClient.Get(urlF)
+	resp, body, err := c.do(ctx, method, urlF, vars, op, files...)
 	if err != nil {
 
-		err = &PushRespErr{Query: urlF, Err: err}
 		return
 	}
-	if resp.StatusCode >= 400 {
 
-		err = &PushRespErr{Query: urlF, Err: ErrFetchImage}
-		return
+	var status struct {
+		Status int `json:"status"`
 	}
-	defer resp.Body.Close()
+	err = json.Unmarshal(body, &status)
+	if err != nil {
 
-	body, err = ioutil.ReadAll(resp.Body)
+		return nil, &PushRespErr{Query: urlF, Err: err}
+	}
+	if status.Status != 1 {
+
+		return nil, &PushRespErr{Query: urlF, Err: newAPIError(op, resp.StatusCode, body)}
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+
+		return nil, &PushRespErr{Query: urlF, Err: err}
+	}
+
+	return
+}
+
+// Pass the sound name to fetch the apropiate sound. Only the format of the
+// name is checked, as messages from other applications may use their own
+// custom sounds.
+func (c *Client) FetchSound(sound string) (body []byte, err error) {
+
+	return c.FetchSoundContext(context.Background(), sound)
+}
+
+// FetchSoundContext is like FetchSound but uses ctx for cancellation and deadlines
+func (c *Client) FetchSoundContext(ctx context.Context, sound string) (body []byte, err error) {
+
+	if !soundName.MatchString(sound) {
+
+		return nil, ErrFetchInvalid
+	}
+
+	urlF := fmt.Sprintf("%s/sounds/%s.wav", c.clientUrl(), sound)
+	_, body, err = c.do(ctx, "GET", urlF, nil, ErrFetchSound)
+	return
+}
+
+// Pass the icon id to fetch the apropiate image
+func (c *Client) FetchImage(icon string) (body []byte, err error) {
+
+	return c.FetchImageContext(context.Background(), icon)
+}
+
+// FetchImageContext is like FetchImage but uses ctx for cancellation and deadlines
+func (c *Client) FetchImageContext(ctx context.Context, icon string) (body []byte, err error) {
+
+	urlF := fmt.Sprintf("%s/icons/%s.png", c.clientUrl(), icon)
+	_, body, err = c.do(ctx, "GET", urlF, nil, ErrFetchImage)
+	return
+}
+
+// Fetch the image attached to a pulled message. Relative references are
+// resolved against the ClientUrl.
+func (c *Client) FetchAttachment(attachment string) (body []byte, err error) {
+
+	return c.FetchAttachmentContext(context.Background(), attachment)
+}
+
+// FetchAttachmentContext is like FetchAttachment but uses ctx for cancellation and deadlines
+func (c *Client) FetchAttachmentContext(ctx context.Context, attachment string) (body []byte, err error) {
+
+	base, err := url.Parse(c.clientUrl() + "/")
 	if err != nil {
 
-		err = &PushRespErr{Query: urlF, Err: err}
 		return
 	}
 
+	ref, err := url.Parse(attachment)
+	if err != nil {
+
+		return nil, &PushRespErr{Query: attachment, Err: err}
+	}
+
+	urlF := base.ResolveReference(ref).String()
+	_, body, err = c.do(ctx, "GET", urlF, nil, ErrFetchAttach)
 	return
 }
 
 type Login struct {
-	Status  int    `json: "status"`
-	Secret  string `json: "secret"`
-	Request string `json: "request"`
-	ID      string `json: "id"`
+	Status  int    `json:"status"`
+	Secret  string `json:"secret"`
+	Request string `json:"request"`
+	ID      string `json:"id"`
 }
 
+// Login with the user name and password and store the device secret.
+// Accounts with two-factor authentication fail with ErrTwoFactor until
+// TwoFactorCode is set to a current code.
 func (c *Client) LoginDevice() (err error) {
 
+	_, err = c.LoginDeviceContext(context.Background())
+	return
+}
+
+// LoginDeviceContext is like LoginDevice but uses ctx for cancellation and
+// deadlines and also returns the login response
+func (c *Client) LoginDeviceContext(ctx context.Context) (login Login, err error) {
+
 	// Some validiation
 	if len(c.UserName) < 1 {
 
-		return ErrUserName
+		err = ErrUserName
+		return
 	}
 	if len(c.UserPassword) < 1 {
 
-		return ErrUserPassword
+		err = ErrUserPassword
+		return
 	}
 
-	err = VerifyDeviceName(c.DeviceName)
+	err = VerifyDeviceName(c.deviceName())
 	if err != nil {
 
 		return
 	}
 
-	// Set the unexported feilds
-	c.deviceOS = "O"
-
 	vars := url.Values{}
 	vars.Add("email", c.UserName)
 	vars.Add("password", c.UserPassword)
+	if len(c.TwoFactorCode) > 0 {
 
-	urlF := fmt.Sprintf("%s%s", BaseUrl, "/users/login.json")
-	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
-	resp, err := httpClient.PostForm(urlF, vars)
-	if err != nil {
-
-		return &PushRespErr{Query: urlF, Err: err}
+		vars.Add("twofa", c.TwoFactorCode)
 	}
-	if resp.StatusCode >= 400 {
 
-		return &PushRespErr{Query: urlF, Err: ErrLoginFailed}
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
+	urlF := fmt.Sprintf("%s%s", c.baseUrl(), "/users/login.json")
+	_, err = c.doJSON(ctx, "POST", urlF, vars, ErrLoginFailed, &login)
 	if err != nil {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		return
 	}
 
-	err = json.Unmarshal(body, &c.Login)
-	if err != nil {
-
-		return &PushRespErr{Query: urlF, Err: err}
-	}
+	c.mu.Lock()
+	c.Login = login
+	c.mu.Unlock()
 
 	return
 }
 
 type RegisterResponse struct {
-	Status  int    `json: "status"`
-	Request string `json: "request"`
-	ID      string `json: "id"`
+	Status  int    `json:"status"`
+	Request string `json:"request"`
+	ID      string `json:"id"`
 }
 
+// Register a new device and store its UUID in DeviceUUID
 func (c *Client) RegisterDevice() (err error) {
 
-	if len(c.Login.Secret) < 1 {
+	_, err = c.RegisterDeviceContext(context.Background())
+	return
+}
 
-		return ErrDeviceAuth
-	}
+// RegisterDeviceContext is like RegisterDevice but uses ctx for
+// cancellation and deadlines and also returns the register response
+func (c *Client) RegisterDeviceContext(ctx context.Context) (reg RegisterResponse, err error) {
 
-	err = VerifyDeviceName(c.DeviceName)
-	if err != nil {
+	secret, _ := c.session()
+	if len(secret) < 1 {
 
+		err = ErrDeviceAuth
 		return
 	}
 
-	vars := url.Values{}
-	vars.Add("secret", c.Login.Secret)
-	vars.Add("name", c.DeviceName)
-	vars.Add("os", c.deviceOS)
-
-	urlF := fmt.Sprintf("%s%s", BaseUrl, "/devices.json")
-	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
-	resp, err := httpClient.PostForm(urlF, vars)
+	name := c.deviceName()
+	err = VerifyDeviceName(name)
 	if err != nil {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		return
 	}
-	if resp.StatusCode >= 400 {
 
-		return &PushRespErr{Query: urlF, Err: ErrDeviceRegister}
-	}
-	defer resp.Body.Close()
+	vars := url.Values{}
+	vars.Add("secret", secret)
+	vars.Add("name", name)
+	vars.Add("os", deviceOS)
 
-	body, err := ioutil.ReadAll(resp.Body)
+	urlF := fmt.Sprintf("%s%s", c.baseUrl(), "/devices.json")
+	_, err = c.doJSON(ctx, "POST", urlF, vars, ErrDeviceRegister, &reg)
 	if err != nil {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		return
 	}
 
-	err = json.Unmarshal(body, &c.RegisterResponse)
-	if err != nil {
-
-		return &PushRespErr{Query: urlF, Err: err}
-	}
-	c.DeviceUUID = c.RegisterResponse.ID
+	c.mu.Lock()
+	c.RegisterResponse = reg
+	c.DeviceUUID = reg.ID
+	c.mu.Unlock()
 
 	return
 }
 
 type MessagesResponse struct {
-	Messages []PullMessage `json: "messages"`
-	User     User          `json: "user"`
+	Messages []PullMessage `json:"messages"`
+	User     User          `json:"user"`
 
-	Status  int    `json: "status"`
-	Request string `json: "request"`
+	Status  int    `json:"status"`
+	Request string `json:"request"`
 }
 
 // Pull Message structure
 type PullMessage struct {
-	ID       int    `json: "id"`
-	Umid     int    `json: "umid"`
-	Title    string `json: "title"`
-	Message  string `json: "message"`
-	App      string `json: "app"`
-	Aid      int    `json: "aid"`
-	Icon     string `json: "icon"`
-	Date     int64  `json: "date"`
-	Priority int    `json: "priority"`
-	Sound    string `json: "sound"`
-	Url      string `json: "url"`
-	UrlTitle string `json: "url_title"`
-	Acked    int    `json: "acked"`
-	Receipt  int    `json: "receipt"`
+	ID       int    `json:"id"`
+	Umid     int    `json:"umid"`
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	App      string `json:"app"`
+	Aid      int    `json:"aid"`
+	Icon     string `json:"icon"`
+	Date     int64  `json:"date"`
+	Priority int    `json:"priority"`
+	Sound    string `json:"sound"`
+	Url      string `json:"url"`
+	UrlTitle string `json:"url_title"`
+	Acked    int    `json:"acked"`
+	Receipt  string `json:"receipt"`
+
+	Attachment string `json:"attachment"` // Url of an attached image, see FetchAttachment
+
+	Html      int `json:"html"`      // Message uses the Pushover HTML subset
+	Monospace int `json:"monospace"` // Message should be shown in a monospace font
+	TTL       int `json:"ttl"`       // Seconds after Date the message should be removed, 0 to keep it
+
+	Undecryptable error `json:"-"` // Why the message could not be decrypted, if it couldn't
 }
 
-type User struct {
-	QuietHours        bool `json: "quiet_hours"`
-	IsAndroidLicensed bool `json: "is_android_licensed"` // Was the app bought on android?
-	IsIOSLicensed     bool `json: "is_ios_licensed"`     // Was the app bought on IOS
-	IsDesktopLicensed bool `json: "is_desktop_licensed"` // Was the app bought on the Pushover store
+// Return when the message should be removed, or the zero time if it has no TTL
+func (m PullMessage) Expires() time.Time {
+
+	if m.TTL < 1 {
+
+		return time.Time{}
+	}
+
+	return time.Unix(m.Date, 0).Add(time.Duration(m.TTL) * time.Second)
 }
 
-func (c *Client) FetchMessages() (fetched int, err error) {
+// Report whether the TTL of the message has passed
+func (m PullMessage) Expired(now time.Time) bool {
 
-	if len(c.Login.Secret) < 1 {
+	expires := m.Expires()
+	return !expires.IsZero() && !now.Before(expires)
+}
 
-		err = ErrDeviceAuth
-		return
-	}
+type User struct {
+	QuietHours        bool `json:"quiet_hours"`
+	IsAndroidLicensed bool `json:"is_android_licensed"` // Was the app bought on android?
+	IsIOSLicensed     bool `json:"is_ios_licensed"`     // Was the app bought on IOS
+	IsDesktopLicensed bool `json:"is_desktop_licensed"` // Was the app bought on the Pushover store
+}
 
-	vars := url.Values{}
-	vars.Add("secret", c.Login.Secret)
-	vars.Add("device_id", c.DeviceUUID)
+// Fetch new messages into MessagesResponse and return how many arrived
+func (c *Client) FetchMessages() (fetched int, err error) {
 
-	urlF := fmt.Sprintf("%s%s%s", BaseUrl, "/messages.json?", vars.Encode())
-	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
-	resp, err := httpClient.Get(urlF)
+	msgs, err := c.FetchMessagesContext(context.Background())
 	if err != nil {
 
-		err = &PushRespErr{Query: urlF, Err: err}
 		return
 	}
-	if resp.StatusCode >= 400 {
 
-		err = &PushRespErr{Query: urlF, Err: ErrPullMsg}
-		return
-	}
-	defer resp.Body.Close()
+	c.mu.Lock()
+	c.MessagesResponse = msgs
+	c.mu.Unlock()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	return len(msgs.Messages), nil
+}
+
+// FetchMessagesContext is like FetchMessages but uses ctx for cancellation
+// and deadlines and returns the messages instead of storing them
+func (c *Client) FetchMessagesContext(ctx context.Context) (msgs MessagesResponse, err error) {
+
+	secret, device := c.session()
+	if len(secret) < 1 {
 
-		err = &PushRespErr{Query: urlF, Err: err}
+		err = ErrDeviceAuth
 		return
 	}
 
-	err = json.Unmarshal(body, &c.MessagesResponse)
+	vars := url.Values{}
+	vars.Add("secret", secret)
+	vars.Add("device_id", device)
+
+	urlF := fmt.Sprintf("%s%s%s", c.baseUrl(), "/messages.json?", vars.Encode())
+	_, err = c.doJSON(ctx, "GET", urlF, nil, ErrPullMsg, &msgs)
 	if err != nil {
 
-		err = &PushRespErr{Query: urlF, Err: err}
 		return
 	}
-	fetched = len(c.MessagesResponse.Messages)
 
-	for _, v := range c.MessagesResponse.Messages {
+	for i := range msgs.Messages {
+
+		v := &msgs.Messages[i]
 
-		// Decrypt the message if required
-		if len(c.Key) > 0 && isEncrypted(v.Message) {
+		// Decrypt the message if required. One that can't be decrypted is
+		// still delivered, with a marker in place of its body.
+		if c.canDecrypt() && isEncrypted(v.Message) {
 
-			msg, err := decryptMessage(c.Key, v.Message)
+			msg, err := c.decryptMessage(*v)
 			if err != nil {
 
-				return 0, err
+				msg = fmt.Sprintf("%s: %s", UndecryptableMessage, err)
+				v.Undecryptable = err
 			}
 			v.Message = msg
 		}
@@ -412,61 +673,93 @@ func (c *Client) FetchMessages() (fetched int, err error) {
 	return
 }
 
+// Return the id of the newest message, or 0 if there are none
+func (r MessagesResponse) Highest() (id int) {
+
+	for _, v := range r.Messages {
+
+		if v.ID > id {
+
+			id = v.ID
+		}
+	}
+
+	return
+}
+
 type MarkReadResponse struct {
-	Status  int    `json: "status"`
-	Request string `json: "request"`
+	Status  int    `json:"status"`
+	Request string `json:"request"`
 }
 
+// Mark every message in MessagesResponse as read
 func (c *Client) MarkReadHighest() (err error) {
 
-	return c.MarkRead(c.MessagesResponse.Messages[len(c.MessagesResponse.Messages)-1].ID)
-}
-
-func (c *Client) MarkRead(id int) (err error) {
+	c.mu.Lock()
+	id := c.MessagesResponse.Highest()
+	c.mu.Unlock()
 
-	if len(c.Login.Secret) < 1 {
+	if id < 1 {
 
-		return ErrDeviceAuth
+		return
 	}
 
-	vars := url.Values{}
-	vars.Add("secret", c.Login.Secret)
-	vars.Add("message", strconv.Itoa(id))
+	return c.MarkRead(id)
+}
 
-	urlF := fmt.Sprintf("%s%s%s%s", BaseUrl, "/devices/", c.DeviceUUID, "/update_highest_message.json")
-	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
-	resp, err := httpClient.PostForm(urlF, vars)
+// Mark every message up to and including id as read
+func (c *Client) MarkRead(id int) (err error) {
+
+	resp, err := c.MarkReadContext(context.Background(), id)
 	if err != nil {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		return
 	}
-	if resp.StatusCode >= 400 {
 
-		return &PushRespErr{Query: urlF, Err: ErrMarkRead}
-	}
-	defer resp.Body.Close()
+	c.mu.Lock()
+	c.MarkReadResponse = resp
+	c.mu.Unlock()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	return
+}
 
-		return &PushRespErr{Query: urlF, Err: err}
-	}
+// MarkReadContext is like MarkRead but uses ctx for cancellation and
+// deadlines and returns the response instead of storing it
+func (c *Client) MarkReadContext(ctx context.Context, id int) (resp MarkReadResponse, err error) {
 
-	err = json.Unmarshal(body, &c.MarkReadResponse)
-	if err != nil {
+	secret, device := c.session()
+	if len(secret) < 1 {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		err = ErrDeviceAuth
+		return
 	}
 
+	vars := url.Values{}
+	vars.Add("secret", secret)
+	vars.Add("message", strconv.Itoa(id))
+
+	urlF := fmt.Sprintf("%s%s%s%s", c.baseUrl(), "/devices/", device, "/update_highest_message.json")
+	_, err = c.doJSON(ctx, "POST", urlF, vars, ErrMarkRead, &resp)
 	return
 }
 
 type PushResponse struct {
-	Receipt string `json: "receipt"`
+	Receipt string `json:"receipt"`
+
+	Status  int      `json:"status"`
+	Request string   `json:"request"`
+	Errors  []string `json:"errors"`
+
+	Accounting Accounting `json:"-"` // Read from the response headers
+	Limits     Limits     `json:"-"` // Accounting parsed, zero if the headers were missing
+}
 
-	Status  int      `json: "status"`
-	Request string   `json: "request"`
-	Errors  []string `json: "errors"`
+// Application message limits as sent in the response headers. See Limits
+// for the parsed values.
+type Accounting struct {
+	AppLimit     string
+	AppRemaining string
+	AppReset     string
 }
 
 func (c *Client) Push(message string) (err error) {
@@ -478,6 +771,17 @@ func (c *Client) Push(message string) (err error) {
 	return c.PushMessage(msg, false)
 }
 
+// PushContext is like Push but uses ctx for cancellation and deadlines and
+// returns the push response
+func (c *Client) PushContext(ctx context.Context, message string) (resp PushResponse, err error) {
+
+	msg := PushMessage{
+
+		Message: message,
+	}
+	return c.PushMessageContext(ctx, msg, false)
+}
+
 // Push message structure
 type PushMessage struct {
 	Device    string // Device name to send to specific devices instead of all
@@ -488,6 +792,11 @@ type PushMessage struct {
 	UrlTitle  string // Url title
 	Timestamp int64  // Timestamp which should be a unixstamp
 	Sound     string // Sound to be played on client device
+	Html      bool   // Message uses the Pushover HTML subset, such as <b> and <a href>
+	Monospace bool   // Message is shown in a monospace font. Cannot be used with Html.
+	TTL       int    // Seconds until the message is removed from devices, 0 to keep it. Ignored for emergency priority.
+
+	Attachment *Attachment // Image sent with the message, never encrypted
 
 	// Emergency notifications
 	Expire   int
@@ -495,8 +804,28 @@ type PushMessage struct {
 	Callback string
 }
 
+// Push a message and store the response in PushResponse and Accounting
 func (c *Client) PushMessage(msg PushMessage, encrypt bool) (err error) {
 
+	resp, err := c.PushMessageContext(context.Background(), msg, encrypt)
+	if err != nil {
+
+		return
+	}
+
+	c.mu.Lock()
+	c.PushResponse = resp
+	c.Accounting = resp.Accounting
+	c.Limits = resp.Limits
+	c.mu.Unlock()
+
+	return
+}
+
+// PushMessageContext is like PushMessage but uses ctx for cancellation and
+// deadlines and returns the response instead of storing it
+func (c *Client) PushMessageContext(ctx context.Context, msg PushMessage, encrypt bool) (resp PushResponse, err error) {
+
 	// Some validations
 	err = VerifyUserKey(c.AppToken)
 	if err != nil {
@@ -516,12 +845,18 @@ func (c *Client) PushMessage(msg PushMessage, encrypt bool) (err error) {
 		return
 	}
 
+	err = c.verifySound(ctx, msg.Sound)
+	if err != nil {
+
+		return
+	}
+
 	if encrypt {
 
-		err := c.encryptMessage(msg)
+		err = c.encryptMessage(&msg)
 		if err != nil {
 
-			return err
+			return
 		}
 	}
 
@@ -535,91 +870,165 @@ func (c *Client) PushMessage(msg PushMessage, encrypt bool) (err error) {
 	vars.Add("title", msg.Title)
 	vars.Add("url", msg.Url)
 	vars.Add("url_title", msg.UrlTitle)
-	vars.Add("expire", string(msg.Expire))
-	vars.Add("retry", string(msg.Retry))
-	vars.Add("priority", string(msg.Priority))
+	vars.Add("expire", strconv.Itoa(msg.Expire))
+	vars.Add("retry", strconv.Itoa(msg.Retry))
+	vars.Add("priority", strconv.Itoa(msg.Priority))
 	vars.Add("timestamp", strconv.FormatInt(msg.Timestamp, 10))
 	vars.Add("sound", msg.Sound)
 	vars.Add("callback", msg.Callback)
+	if msg.Html {
 
-	urlF := fmt.Sprintf("%s%s", BaseUrl, "/messages.json")
-	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
-	resp, err := httpClient.PostForm(urlF, vars)
-	if err != nil {
+		vars.Add("html", btos(msg.Html))
+	}
+	if msg.Monospace {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		vars.Add("monospace", btos(msg.Monospace))
 	}
-	if resp.StatusCode >= 400 {
+	if msg.TTL > 0 {
 
-		return &PushRespErr{Query: urlF, Err: ErrPushMsg}
+		vars.Add("ttl", strconv.Itoa(msg.TTL))
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	var files []formFile
+	if msg.Attachment != nil {
+
+		f, err := msg.Attachment.read()
+		if err != nil {
+
+			return resp, err
+		}
+		files = append(files, f)
+	}
+
+	var taken bool
+	if c.Limiter != nil {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		taken, err = c.Limiter.take(ctx)
+		if err != nil {
+
+			return
+		}
 	}
 
-	err = json.Unmarshal(body, &c.PushResponse)
+	urlF := fmt.Sprintf("%s%s", c.baseUrl(), "/messages.json")
+	httpResp, err := c.doJSON(ctx, "POST", urlF, vars, ErrPushMsg, &resp, files...)
 	if err != nil {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		switch {
+
+		case c.Limiter != nil && errors.Is(err, ErrRateLimited):
+			c.Limiter.exhausted()
+		case taken && notSent(err):
+			c.Limiter.refund()
+		}
+		return
 	}
 
 	// Update accounting
-	c.Accounting.AppLimit = resp.Header.Get("X-Limit-App-Limit")
-	c.Accounting.AppRemaining = resp.Header.Get("X-Limit-App-Remaining")
-	c.Accounting.AppReset = resp.Header.Get("X-Limit-App-Reset")
+	resp.Accounting.AppLimit = httpResp.Header.Get("X-Limit-App-Limit")
+	resp.Accounting.AppRemaining = httpResp.Header.Get("X-Limit-App-Remaining")
+	resp.Accounting.AppReset = httpResp.Header.Get("X-Limit-App-Reset")
+
+	limits, ok := parseLimits(httpResp.Header)
+	if ok {
+
+		resp.Limits = limits
+		if c.Limiter != nil {
+
+			c.Limiter.Update(limits)
+		}
+	}
 
 	return
 }
 
 type Receipt struct {
-	Acknowledged    int   `json: "acknowledged"`
-	AcknowledgedAt  int   `json: "acknowledged_at"`
-	LastDeliveredAt int   `json: "last_delivered_at"`
-	Expired         int   `json: "expired"`
-	ExpiresAt       int64 `json: "expires_at"`
-	CalledBack      int   `json: "called_back"`
-	CalledBackAt    int64 `json: "called_back_at"`
-
-	Status  int    `json: "status"`
-	Request string `json: "request"`
+	Acknowledged    int   `json:"acknowledged"`
+	AcknowledgedAt  int   `json:"acknowledged_at"`
+	LastDeliveredAt int   `json:"last_delivered_at"`
+	Expired         int   `json:"expired"`
+	ExpiresAt       int64 `json:"expires_at"`
+	CalledBack      int   `json:"called_back"`
+	CalledBackAt    int64 `json:"called_back_at"`
+
+	Status  int    `json:"status"`
+	Request string `json:"request"`
 }
 
+// Get the receipt of an emergency message and store it in ReceiptResponse
 func (c *Client) GetReceipt(receipt string) (err error) {
 
-	err = VerifyReceipt(receipt)
+	r, err := c.GetReceiptContext(context.Background(), receipt)
 	if err != nil {
 
 		return
 	}
 
-	urlF := fmt.Sprintf("%s/%s.json?token=%s", BaseUrl, "/receipts", receipt, c.AppToken)
-	httpClient := &http.Client{Transport: &http.Transport{Dial: c.dial}}
-	resp, err := httpClient.Get(urlF)
+	c.mu.Lock()
+	c.ReceiptResponse = r
+	c.mu.Unlock()
+
+	return
+}
+
+// GetReceiptContext is like GetReceipt but uses ctx for cancellation and
+// deadlines and returns the receipt instead of storing it
+func (c *Client) GetReceiptContext(ctx context.Context, receipt string) (r Receipt, err error) {
+
+	err = VerifyReceipt(receipt)
 	if err != nil {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		return
 	}
-	if resp.StatusCode >= 400 {
 
-		return &PushRespErr{Query: urlF, Err: ErrReceipt}
-	}
-	defer resp.Body.Close()
+	urlF := fmt.Sprintf("%s/receipts/%s.json?token=%s", c.baseUrl(), receipt, c.AppToken)
+	_, err = c.doJSON(ctx, "GET", urlF, nil, ErrReceipt, &r)
+	return
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+type AcknowledgeResponse struct {
+	Status  int    `json:"status"`
+	Request string `json:"request"`
+}
+
+// Acknowledge an emergency priority message so it stops re-alerting on
+// every device. Pass the receipt of the pulled message.
+func (c *Client) Acknowledge(receipt string) (err error) {
+
+	resp, err := c.AcknowledgeContext(context.Background(), receipt)
 	if err != nil {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		return
+	}
+
+	c.mu.Lock()
+	c.AcknowledgeResponse = resp
+	c.mu.Unlock()
+
+	return
+}
+
+// AcknowledgeContext is like Acknowledge but uses ctx for cancellation and
+// deadlines and returns the response instead of storing it
+func (c *Client) AcknowledgeContext(ctx context.Context, receipt string) (resp AcknowledgeResponse, err error) {
+
+	secret, _ := c.session()
+	if len(secret) < 1 {
+
+		err = ErrDeviceAuth
+		return
 	}
 
-	err = json.Unmarshal(body, &c.PushResponse)
+	err = VerifyReceipt(receipt)
 	if err != nil {
 
-		return &PushRespErr{Query: urlF, Err: err}
+		return
 	}
 
+	vars := url.Values{}
+	vars.Add("secret", secret)
+
+	urlF := fmt.Sprintf("%s/receipts/%s/acknowledge.json", c.baseUrl(), receipt)
+	_, err = c.doJSON(ctx, "POST", urlF, vars, ErrAcknowledge, &resp)
 	return
 }
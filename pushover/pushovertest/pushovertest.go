@@ -0,0 +1,1230 @@
+/*
+	Package pushovertest runs a fake Pushover API on a local httptest server.
+
+	It speaks enough of the Message and Open Client APIs, including the
+	/push stream, for code built on the pushover package to be tested
+	offline. Every path can be given scripted responses which are served in
+	order before the default behaviour takes over.
+
+		srv := pushovertest.NewServer()
+		defer srv.Close()
+
+		srv.QueueMessage(pushover.PullMessage{ID: 1, Message: "hello"})
+
+		client := srv.Client()
+		client.UserName = srv.Email
+		client.UserPassword = srv.Password
+*/
+
+package pushovertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+// Default credentials handed out by a new Server
+const (
+	Email    = "user@example.com"
+	Password = "password"
+	Secret   = "fakesecret0123456789abcdefghijk"
+	DeviceID = "fakedevice0123456789abcdefghijklmnop"
+	AppToken = "azGDORePK8gMaC0QOYAMyEEuzJnyUi"
+	UserKey  = "uQiRzpo4DXghDmr9QzzfQu27cmVRsG"
+	Device   = "desktop"
+	AppName  = "OpenPushOver"
+	AppID    = 1
+	AppLimit = 10000
+)
+
+// An attachment received with a pushed message
+type Attachment struct {
+	Name string
+	Type string
+	Data []byte
+}
+
+// An application other than AppToken, see AddApp
+type app struct {
+	name string
+	id   int
+}
+
+// A scripted response served instead of the default behaviour
+type Response struct {
+	Status int         // HTTP status code, defaults to 200
+	Header http.Header // Extra response headers
+	Body   string      // Raw response body
+}
+
+type Server struct {
+	*httptest.Server
+
+	Email    string // Accepted by users/login.json
+	Password string // Accepted by users/login.json
+	TwoFA    string // Code users/login.json asks for with a 412 when set
+	Secret   string // Returned by users/login.json and required by the Open Client calls
+	DeviceID string // Returned by devices.json and required by the Open Client calls
+	AppToken string // Required by the Message API calls
+	UserKey  string // Required by the Message API calls
+	AppName  string // App of messages delivered by Loopback
+	AppID    int    // Aid of messages delivered by Loopback
+
+	Loopback bool // Deliver pushed messages to messages.json, as the real service does
+
+	Devices []string // Active device names of UserKey
+
+	AppLimit int       // Messages accepted before messages.json answers 429
+	AppReset time.Time // Reported reset time of the limits
+
+	mu       sync.Mutex
+	requests int
+	scripts  map[string][]Response
+	messages []pushover.PullMessage
+	highest  int
+	pushed   []url.Values
+	attached map[int]Attachment
+	receipts map[string]pushover.Receipt
+	groups   map[string]*pushover.Group
+	devices  map[string]*pushover.Device
+	nGroups  int
+	sounds   map[string][]byte
+	icons    map[string][]byte
+	images   map[string][]byte
+	apps     map[string]app
+	streams  map[*websocket.Conn]bool
+	logins   int
+}
+
+// Start a new fake server. The caller should call Close when finished.
+func NewServer() *Server {
+
+	s := &Server{
+
+		Email:    Email,
+		Password: Password,
+		Secret:   Secret,
+		DeviceID: DeviceID,
+		AppToken: AppToken,
+		UserKey:  UserKey,
+		AppName:  AppName,
+		AppID:    AppID,
+
+		Devices: []string{Device},
+
+		AppLimit: AppLimit,
+		AppReset: time.Now().AddDate(0, 1, 0).Truncate(time.Hour),
+
+		scripts:  make(map[string][]Response),
+		receipts: make(map[string]pushover.Receipt),
+		groups:   make(map[string]*pushover.Group),
+		devices:  make(map[string]*pushover.Device),
+		attached: make(map[int]Attachment),
+		sounds:   make(map[string][]byte),
+		icons:    make(map[string][]byte),
+		images:   make(map[string][]byte),
+		apps:     make(map[string]app),
+		streams:  make(map[*websocket.Conn]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/1/users/login.json", s.handleLogin)
+	mux.HandleFunc("/1/devices.json", s.handleRegister)
+	mux.HandleFunc("/1/devices/", s.handleDevice)
+	mux.HandleFunc("/1/messages.json", s.handleMessages)
+	mux.HandleFunc("/1/receipts/", s.handleReceipt)
+	mux.HandleFunc("/1/apps/limits.json", s.handleLimits)
+	mux.HandleFunc("/1/users/validate.json", s.handleValidate)
+	mux.HandleFunc("/1/groups.json", s.handleGroups)
+	mux.HandleFunc("/1/sounds.json", s.handleSounds)
+	mux.HandleFunc("/1/groups/", s.handleGroup)
+	mux.HandleFunc("/sounds/", s.handleSound)
+	mux.HandleFunc("/icons/", s.handleIcon)
+	mux.HandleFunc("/attachments/", s.handleAttachment)
+	mux.Handle("/push", websocket.Handler(s.handleStream))
+
+	s.Server = httptest.NewServer(s.scripted(mux))
+	return s
+}
+
+// Return a client pointed at the fake server
+func (s *Server) Client() *pushover.Client {
+
+	return &pushover.Client{
+
+		BaseUrl:   s.URL + "/1",
+		ClientUrl: s.URL,
+		StreamUrl: "ws" + strings.TrimPrefix(s.URL, "http") + "/push",
+
+		AppToken: s.AppToken,
+		UserKey:  s.UserKey,
+	}
+}
+
+// Queue responses for a request path such as /1/messages.json. They are
+// served once each, in order, before the default behaviour resumes.
+func (s *Server) Script(path string, responses ...Response) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scripts[path] = append(s.scripts[path], responses...)
+}
+
+// Queue messages to be returned by messages.json and tell every logged in
+// stream that new messages are waiting
+func (s *Server) QueueMessage(msgs ...pushover.PullMessage) {
+
+	s.mu.Lock()
+	s.messages = append(s.messages, msgs...)
+	s.mu.Unlock()
+
+	s.Notify(pushover.FrameMessage)
+}
+
+// Send a frame to every logged in stream. Error and session closed frames
+// also close the streams, as the real server does.
+func (s *Server) Notify(frame byte) {
+
+	s.mu.Lock()
+	conns := s.listeners(frame)
+	s.mu.Unlock()
+
+	notify(conns, frame)
+}
+
+// Return the streams to send a frame to, forgetting them if the frame
+// closes them. Must be called with s.mu held.
+func (s *Server) listeners(frame byte) (conns []*websocket.Conn) {
+
+	for ws := range s.streams {
+
+		conns = append(conns, ws)
+		if frame == pushover.FrameError || frame == pushover.FrameClosed {
+
+			delete(s.streams, ws)
+		}
+	}
+
+	return
+}
+
+// Send a frame to each stream without holding s.mu, so a slow client
+// cannot hold up the other handlers
+func notify(conns []*websocket.Conn, frame byte) {
+
+	for _, ws := range conns {
+
+		websocket.Message.Send(ws, string(frame))
+		if frame == pushover.FrameError || frame == pushover.FrameClosed {
+
+			ws.Close()
+		}
+	}
+}
+
+// Return the number of logged in streams
+func (s *Server) Streams() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.streams)
+}
+
+// Return the number of successful stream logins since the server started
+func (s *Server) StreamLogins() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.logins
+}
+
+// Return the highest message id marked as read
+func (s *Server) Highest() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.highest
+}
+
+// Return the form values of every message pushed so far
+func (s *Server) Pushed() []url.Values {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pushed := make([]url.Values, len(s.pushed))
+	copy(pushed, s.pushed)
+	return pushed
+}
+
+// Return the attachment of the i-th pushed message, counting from 0
+func (s *Server) PushedAttachment(i int) (a Attachment, ok bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok = s.attached[i]
+	return
+}
+
+// Set the receipt returned for the given receipt id
+func (s *Server) SetReceipt(id string, r pushover.Receipt) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.receipts[id] = r
+}
+
+// Return the receipt stored for the given receipt id
+func (s *Server) Receipt(id string) (r pushover.Receipt, ok bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok = s.receipts[id]
+	return
+}
+
+// Return a copy of a group created through groups.json
+func (s *Server) Group(key string) (g pushover.Group, ok bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.groups[key]
+	if !ok {
+
+		return
+	}
+	g = *p
+	g.Users = append([]pushover.GroupUser(nil), p.Users...)
+	return
+}
+
+// Register a device for the user, such as a stale one left by an old install
+func (s *Server) AddDevice(d pushover.Device) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.devices[d.ID] = &d
+}
+
+// Return a registered device
+func (s *Server) Device(id string) (d pushover.Device, ok bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.devices[id]
+	if ok {
+
+		d = *p
+	}
+	return
+}
+
+// Set the wav data returned for a sound name. Sounds other than the
+// built in ones are listed by sounds.json as custom sounds.
+func (s *Server) SetSound(name string, b []byte) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sounds[name] = b
+}
+
+// Set the png data returned for an icon id
+func (s *Server) SetIcon(name string, b []byte) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.icons[name] = b
+}
+
+// Serve an image under /attachments/ and return the reference to put in
+// the Attachment field of a queued message
+func (s *Server) SetAttachment(name string, b []byte) string {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.images[name] = b
+	return s.URL + "/attachments/" + name
+}
+
+// Accept pushes from another application and return its Aid. Messages it
+// pushes are delivered by Loopback under name.
+func (s *Server) AddApp(token, name string) int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	aid := s.AppID + len(s.apps) + 1
+	s.apps[token] = app{name: name, id: aid}
+	return aid
+}
+
+func (s *Server) scripted(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		s.mu.Lock()
+		s.requests++
+		queue := s.scripts[r.URL.Path]
+		if len(queue) < 1 {
+
+			s.mu.Unlock()
+			next.ServeHTTP(w, r)
+			return
+		}
+		resp := queue[0]
+		s.scripts[r.URL.Path] = queue[1:]
+		s.mu.Unlock()
+
+		for k, v := range resp.Header {
+
+			w.Header()[k] = v
+		}
+		if resp.Status == 0 {
+
+			resp.Status = http.StatusOK
+		}
+		w.WriteHeader(resp.Status)
+		fmt.Fprint(w, resp.Body)
+	})
+}
+
+// Must be called with s.mu held
+func (s *Server) requestID() string {
+
+	return fmt.Sprintf("%032x", s.requests)
+}
+
+// Must be called with s.mu held
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v map[string]interface{}) {
+
+	v["request"] = s.requestID()
+	if _, ok := v["status"]; !ok {
+
+		v["status"] = 1
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Must be called with s.mu held. Mirrors the error bodies of the real API.
+func (s *Server) writeError(w http.ResponseWriter, status int, field, msg string) {
+
+	v := map[string]interface{}{
+
+		"status": 0,
+		"errors": []string{msg},
+	}
+	if len(field) > 0 {
+
+		v[field] = "invalid"
+	}
+	s.writeJSON(w, status, v)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method != "POST" {
+
+		s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+	if r.FormValue("email") != s.Email || r.FormValue("password") != s.Password {
+
+		s.writeError(w, http.StatusBadRequest, "", "invalid email and/or password")
+		return
+	}
+	if len(s.TwoFA) > 0 && r.FormValue("twofa") != s.TwoFA {
+
+		s.writeError(w, http.StatusPreconditionFailed, "", "two-factor authentication is enabled, please supply a twofa code")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+		"id":     s.UserKey,
+		"secret": s.Secret,
+	})
+}
+
+// Handles /1/devices.json, listing devices on GET and registering one on POST
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.FormValue("secret") != s.Secret {
+
+		s.writeError(w, http.StatusBadRequest, "secret", "secret is invalid")
+		return
+	}
+	if r.Method == "GET" {
+
+		devices := []pushover.Device{}
+		for _, d := range s.devices {
+
+			devices = append(devices, *d)
+		}
+		sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+			"devices": devices,
+		})
+		return
+	}
+	if r.Method != "POST" {
+
+		s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+	if err := pushover.VerifyDeviceName(r.FormValue("name")); err != nil || len(r.FormValue("name")) < 1 {
+
+		s.writeError(w, http.StatusBadRequest, "name", "name is invalid")
+		return
+	}
+
+	s.devices[s.DeviceID] = &pushover.Device{ID: s.DeviceID, Name: r.FormValue("name"), OS: r.FormValue("os")}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+		"id": s.DeviceID,
+	})
+}
+
+// Handles /1/devices/{id}/{action}.json
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/1/devices/"), ".json"), "/")
+	if len(parts) == 2 && parts[1] == "update_highest_message" {
+
+		s.handleMarkRead(w, r, parts[0])
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(parts) != 2 {
+
+		s.writeError(w, http.StatusNotFound, "", "not found")
+		return
+	}
+	if r.Method != "POST" {
+
+		s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+	if r.FormValue("secret") != s.Secret {
+
+		s.writeError(w, http.StatusBadRequest, "secret", "secret is invalid")
+		return
+	}
+
+	d, ok := s.devices[parts[0]]
+	if !ok {
+
+		s.writeError(w, http.StatusNotFound, "device", "device not found")
+		return
+	}
+
+	switch parts[1] {
+
+	case "rename":
+		if err := pushover.VerifyDeviceName(r.FormValue("name")); err != nil || len(r.FormValue("name")) < 1 {
+
+			s.writeError(w, http.StatusBadRequest, "name", "name is invalid")
+			return
+		}
+		d.Name = r.FormValue("name")
+	case "disable":
+		d.Disabled = true
+	case "delete":
+		delete(s.devices, d.ID)
+	default:
+		s.writeError(w, http.StatusNotFound, "", "not found")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+// Handles /1/devices/{id}/update_highest_message.json
+func (s *Server) handleMarkRead(w http.ResponseWriter, r *http.Request, device string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method != "POST" {
+
+		s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+	if r.FormValue("secret") != s.Secret {
+
+		s.writeError(w, http.StatusBadRequest, "secret", "secret is invalid")
+		return
+	}
+	if device != s.DeviceID {
+
+		s.writeError(w, http.StatusBadRequest, "device_id", "device id is invalid")
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("message"))
+	if err != nil {
+
+		s.writeError(w, http.StatusBadRequest, "message", "message is invalid")
+		return
+	}
+	if id > s.highest {
+
+		s.highest = id
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+
+	case "GET":
+		s.fetchMessages(w, r)
+	case "POST":
+		s.pushMessage(w, r)
+	default:
+		s.mu.Lock()
+		s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) fetchMessages(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.FormValue("secret") != s.Secret {
+
+		s.writeError(w, http.StatusBadRequest, "secret", "secret is invalid")
+		return
+	}
+	if r.FormValue("device_id") != s.DeviceID {
+
+		s.writeError(w, http.StatusBadRequest, "device_id", "device id is invalid")
+		return
+	}
+
+	msgs := []pushover.PullMessage{}
+	for _, v := range s.messages {
+
+		if v.ID > s.highest {
+
+			msgs = append(msgs, v)
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+		"messages": msgs,
+		"user": pushover.User{
+
+			IsDesktopLicensed: true,
+		},
+	})
+}
+
+func (s *Server) pushMessage(w http.ResponseWriter, r *http.Request) {
+
+	// Streams told about a delivered message, once s.mu is released
+	var conns []*websocket.Conn
+	defer func() { notify(conns, pushover.FrameMessage) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+
+		err = r.ParseMultipartForm(2 * pushover.AttachmentLimit)
+	} else {
+
+		err = r.ParseForm()
+	}
+	if err != nil {
+
+		s.writeError(w, http.StatusBadRequest, "", err.Error())
+		return
+	}
+
+	var attachment *Attachment
+	if r.MultipartForm != nil && len(r.MultipartForm.File["attachment"]) > 0 {
+
+		fh := r.MultipartForm.File["attachment"][0]
+		f, err := fh.Open()
+		if err != nil {
+
+			s.writeError(w, http.StatusBadRequest, "attachment", err.Error())
+			return
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil || len(data) > pushover.AttachmentLimit {
+
+			s.writeError(w, http.StatusBadRequest, "attachment", "attachment is too large or could not be read")
+			return
+		}
+		attachment = &Attachment{Name: fh.Filename, Type: fh.Header.Get("Content-Type"), Data: data}
+	}
+	from := app{name: s.AppName, id: s.AppID}
+	if token := r.PostForm.Get("token"); token != s.AppToken {
+
+		var ok bool
+		from, ok = s.apps[token]
+		if !ok {
+
+			s.writeError(w, http.StatusBadRequest, "token", "application token is invalid")
+			return
+		}
+	}
+	if !s.isRecipient(r.PostForm.Get("user")) {
+
+		s.writeError(w, http.StatusBadRequest, "user", "user identifier is not a valid user, group, or subscribed user key")
+		return
+	}
+	if !s.hasDevice(r.PostForm.Get("device")) {
+
+		s.writeError(w, http.StatusBadRequest, "device", "device name is not valid for user")
+		return
+	}
+	if len(r.PostForm.Get("message")) < 1 {
+
+		s.writeError(w, http.StatusBadRequest, "message", "message cannot be blank")
+		return
+	}
+	if len(s.pushed) >= s.AppLimit {
+
+		s.writeError(w, http.StatusTooManyRequests, "", "application is over its monthly message limit")
+		return
+	}
+	if attachment != nil {
+
+		s.attached[len(s.pushed)] = *attachment
+	}
+	s.pushed = append(s.pushed, r.PostForm)
+	if s.Loopback {
+
+		conns = s.deliver(r.PostForm, from, attachment)
+	}
+
+	v := map[string]interface{}{}
+	if r.PostForm.Get("priority") == strconv.Itoa(pushover.HighestPriority) {
+
+		id := fmt.Sprintf("r%029d", len(s.pushed))
+		s.receipts[id] = pushover.Receipt{Status: 1}
+		v["receipt"] = id
+	}
+
+	w.Header().Set("X-Limit-App-Limit", strconv.Itoa(s.AppLimit))
+	w.Header().Set("X-Limit-App-Remaining", strconv.Itoa(s.AppLimit-len(s.pushed)))
+	w.Header().Set("X-Limit-App-Reset", strconv.FormatInt(s.AppReset.Unix(), 10))
+	s.writeJSON(w, http.StatusOK, v)
+}
+
+// Queue a pushed message for messages.json the way a device would receive
+// it, with any attachment served under /attachments/. Returns the streams
+// to notify.
+func (s *Server) deliver(form url.Values, from app, attachment *Attachment) []*websocket.Conn {
+
+	id := s.highest
+	for _, v := range s.messages {
+
+		if v.ID > id {
+
+			id = v.ID
+		}
+	}
+	id++
+
+	m := pushover.PullMessage{
+
+		ID:       id,
+		Umid:     id,
+		Title:    form.Get("title"),
+		Message:  form.Get("message"),
+		App:      from.name,
+		Aid:      from.id,
+		Date:     time.Now().Unix(),
+		Sound:    form.Get("sound"),
+		Url:      form.Get("url"),
+		UrlTitle: form.Get("url_title"),
+	}
+	if ts, _ := strconv.ParseInt(form.Get("timestamp"), 10, 64); ts > 0 {
+
+		m.Date = ts
+	}
+	m.Priority, _ = strconv.Atoi(form.Get("priority"))
+	m.Html, _ = strconv.Atoi(form.Get("html"))
+	m.Monospace, _ = strconv.Atoi(form.Get("monospace"))
+	m.TTL, _ = strconv.Atoi(form.Get("ttl"))
+
+	if attachment != nil {
+
+		name := fmt.Sprintf("%d-%s", id, attachment.Name)
+		s.images[name] = attachment.Data
+		m.Attachment = s.URL + "/attachments/" + name
+	}
+
+	s.messages = append(s.messages, m)
+	return s.listeners(pushover.FrameMessage)
+}
+
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.FormValue("token") != s.AppToken {
+
+		s.writeError(w, http.StatusBadRequest, "token", "application token is invalid")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+		"limit":     s.AppLimit,
+		"remaining": s.AppLimit - len(s.pushed),
+		"reset":     s.AppReset.Unix(),
+	})
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method != "POST" {
+
+		s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+	if r.FormValue("token") != s.AppToken {
+
+		s.writeError(w, http.StatusBadRequest, "token", "application token is invalid")
+		return
+	}
+	if _, ok := s.groups[r.FormValue("user")]; ok {
+
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+			"group":    1,
+			"devices":  []string{},
+			"licenses": []string{},
+		})
+		return
+	}
+	if r.FormValue("user") != s.UserKey {
+
+		s.writeError(w, http.StatusBadRequest, "user", "user key is invalid")
+		return
+	}
+	if !s.hasDevice(r.FormValue("device")) {
+
+		s.writeError(w, http.StatusBadRequest, "device", "device name is not valid for user")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+		"group":    0,
+		"devices":  s.Devices,
+		"licenses": []string{"Desktop"},
+	})
+}
+
+// Must be called with s.mu held
+func (s *Server) isRecipient(key string) bool {
+
+	_, ok := s.groups[key]
+	return ok || key == s.UserKey
+}
+
+// Must be called with s.mu held. An empty name means all devices.
+func (s *Server) hasDevice(name string) bool {
+
+	if len(name) < 1 {
+
+		return true
+	}
+
+	for _, v := range s.Devices {
+
+		if v == name {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handles /1/groups.json, listing groups on GET and creating one on POST
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.FormValue("token") != s.AppToken {
+
+		s.writeError(w, http.StatusBadRequest, "token", "application token is invalid")
+		return
+	}
+
+	switch r.Method {
+
+	case "GET":
+		groups := []pushover.Group{}
+		for _, g := range s.groups {
+
+			groups = append(groups, pushover.Group{Key: g.Key, Name: g.Name})
+		}
+		sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+			"groups": groups,
+		})
+
+	case "POST":
+		name := r.FormValue("name")
+		if len(name) < 1 || len(name) > pushover.GroupNameLimit {
+
+			s.writeError(w, http.StatusBadRequest, "name", "name is invalid")
+			return
+		}
+
+		s.nGroups++
+		key := fmt.Sprintf("g%029d", s.nGroups)
+		s.groups[key] = &pushover.Group{Key: key, Name: name, Users: []pushover.GroupUser{}}
+
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+			"group": key,
+		})
+
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+	}
+}
+
+// Handles /1/groups/{key}.json and /1/groups/{key}/{action}.json
+func (s *Server) handleGroup(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/1/groups/"), ".json"), "/")
+	if r.FormValue("token") != s.AppToken {
+
+		s.writeError(w, http.StatusBadRequest, "token", "application token is invalid")
+		return
+	}
+
+	g, ok := s.groups[parts[0]]
+	if !ok {
+
+		s.writeError(w, http.StatusNotFound, "group", "group not found or you are not authorized to edit it")
+		return
+	}
+
+	if len(parts) == 1 {
+
+		if r.Method != "GET" {
+
+			s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+			return
+		}
+
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+			"name":  g.Name,
+			"users": g.Users,
+		})
+		return
+	}
+
+	if len(parts) != 2 {
+
+		s.writeError(w, http.StatusNotFound, "", "not found")
+		return
+	}
+	if r.Method != "POST" {
+
+		s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+
+	if parts[1] == "rename" {
+
+		name := r.FormValue("name")
+		if len(name) < 1 || len(name) > pushover.GroupNameLimit {
+
+			s.writeError(w, http.StatusBadRequest, "name", "name is invalid")
+			return
+		}
+		g.Name = name
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{})
+		return
+	}
+
+	user, device := r.FormValue("user"), r.FormValue("device")
+	if err := pushover.VerifyUserKey(user); err != nil {
+
+		s.writeError(w, http.StatusBadRequest, "user", "user key is invalid")
+		return
+	}
+
+	i := -1
+	for j, v := range g.Users {
+
+		if v.User == user && v.Device == device {
+
+			i = j
+		}
+	}
+
+	switch {
+
+	case parts[1] == "add_user" && i < 0:
+		g.Users = append(g.Users, pushover.GroupUser{User: user, Device: device, Memo: r.FormValue("memo")})
+	case parts[1] == "add_user":
+		s.writeError(w, http.StatusBadRequest, "user", "user is already a member of this group")
+		return
+	case i < 0:
+		s.writeError(w, http.StatusBadRequest, "user", "user is not a member of this group")
+		return
+	case parts[1] == "remove_user":
+		g.Users = append(g.Users[:i], g.Users[i+1:]...)
+	case parts[1] == "enable_user":
+		g.Users[i].Disabled = false
+	case parts[1] == "disable_user":
+		g.Users[i].Disabled = true
+	default:
+		s.writeError(w, http.StatusNotFound, "", "not found")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+// Handles /1/receipts/{receipt}.json and /1/receipts/{receipt}/acknowledge.json
+func (s *Server) handleReceipt(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/1/receipts/"), ".json")
+	if strings.HasSuffix(id, "/acknowledge") {
+
+		s.acknowledge(w, r, strings.TrimSuffix(id, "/acknowledge"))
+		return
+	}
+
+	if r.FormValue("token") != s.AppToken {
+
+		s.writeError(w, http.StatusBadRequest, "token", "application token is invalid")
+		return
+	}
+
+	receipt, ok := s.receipts[id]
+	if !ok {
+
+		s.writeError(w, http.StatusNotFound, "receipt", "receipt not found; may be invalid or expired")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	receipt.Status = 1
+	receipt.Request = s.requestID()
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// Must be called with s.mu held
+func (s *Server) acknowledge(w http.ResponseWriter, r *http.Request, id string) {
+
+	if r.Method != "POST" {
+
+		s.writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+	if r.FormValue("secret") != s.Secret {
+
+		s.writeError(w, http.StatusBadRequest, "secret", "secret is invalid")
+		return
+	}
+
+	receipt, ok := s.receipts[id]
+	if !ok {
+
+		s.writeError(w, http.StatusNotFound, "receipt", "receipt not found; may be invalid or expired")
+		return
+	}
+	receipt.Acknowledged = 1
+	receipt.AcknowledgedAt = int(time.Now().Unix())
+	s.receipts[id] = receipt
+
+	for i := range s.messages {
+
+		if s.messages[i].Receipt == id {
+
+			s.messages[i].Acked = 1
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (s *Server) handleSounds(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.FormValue("token") != s.AppToken {
+
+		s.writeError(w, http.StatusBadRequest, "token", "application token is invalid")
+		return
+	}
+
+	sounds := make(map[string]string)
+	for k, v := range pushover.DefaultSounds {
+
+		sounds[k] = v
+	}
+	for k := range s.sounds {
+
+		if _, ok := sounds[k]; !ok {
+
+			sounds[k] = k
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+
+		"sounds": sounds,
+	})
+}
+
+// Handles /sounds/{name}.wav
+func (s *Server) handleSound(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sounds/"), ".wav")
+	b, ok := s.sounds[name]
+	if !ok {
+
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Write(b)
+}
+
+// Handles /icons/{name}.png
+func (s *Server) handleIcon(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/icons/"), ".png")
+	b, ok := s.icons[name]
+	if !ok {
+
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(b)
+}
+
+// Handles /attachments/{name}
+func (s *Server) handleAttachment(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.images[strings.TrimPrefix(r.URL.Path, "/attachments/")]
+	if !ok {
+
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(b))
+	w.Write(b)
+}
+
+// Handles the /push stream. The first message must be the login line.
+func (s *Server) handleStream(ws *websocket.Conn) {
+
+	var login string
+	err := websocket.Message.Receive(ws, &login)
+	if err != nil {
+
+		return
+	}
+
+	s.mu.Lock()
+	if strings.TrimSpace(login) != fmt.Sprintf("login:%s:%s", s.DeviceID, s.Secret) {
+
+		s.mu.Unlock()
+		websocket.Message.Send(ws, string(pushover.FrameError))
+		ws.Close()
+		return
+	}
+	s.streams[ws] = true
+	s.logins++
+	s.mu.Unlock()
+
+	// Block until the client hangs up
+	var discard []byte
+	for websocket.Message.Receive(ws, &discard) == nil {
+	}
+
+	s.mu.Lock()
+	delete(s.streams, ws)
+	s.mu.Unlock()
+}
@@ -0,0 +1,270 @@
+package pushovertest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+func TestDeviceSession(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	srv.QueueMessage(pushover.PullMessage{ID: 1, Message: "first"}, pushover.PullMessage{ID: 2, Message: "second"})
+
+	c := srv.Client()
+	c.UserName = srv.Email
+	c.UserPassword = srv.Password
+	c.DeviceName = "test"
+
+	err := c.LoginDevice()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if c.Login.Secret != srv.Secret {
+
+		t.Fatalf("got secret %q, want %q", c.Login.Secret, srv.Secret)
+	}
+
+	err = c.RegisterDevice()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if c.DeviceUUID != srv.DeviceID {
+
+		t.Fatalf("got device %q, want %q", c.DeviceUUID, srv.DeviceID)
+	}
+	if d, ok := srv.Device(srv.DeviceID); !ok || d.Name != "test" {
+
+		t.Fatalf("registered device is %+v", d)
+	}
+
+	n, err := c.FetchMessages()
+	if err != nil || n != 2 {
+
+		t.Fatalf("fetched %d messages, %v", n, err)
+	}
+
+	err = c.MarkRead(1)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if srv.Highest() != 1 {
+
+		t.Fatalf("highest is %d, want 1", srv.Highest())
+	}
+
+	// Read messages are not served again
+	n, err = c.FetchMessages()
+	if err != nil || n != 1 || c.MessagesResponse.Messages[0].ID != 2 {
+
+		t.Fatalf("fetched %d messages, %v", n, err)
+	}
+}
+
+func TestBadCredentials(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.UserName = srv.Email
+	c.UserPassword = "wrong"
+
+	err := c.LoginDevice()
+	if !errors.Is(err, pushover.ErrLoginFailed) {
+
+		t.Fatalf("got %v, want ErrLoginFailed", err)
+	}
+
+	c.SetSecret("wrong")
+	c.DeviceUUID = srv.DeviceID
+
+	_, err = c.FetchMessages()
+	if !errors.Is(err, pushover.ErrSecretExpired) {
+
+		t.Fatalf("got %v, want ErrSecretExpired", err)
+	}
+}
+
+// Scripted responses are served once each, in order, then the default
+// behaviour resumes
+func TestScript(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	srv.Script("/1/messages.json",
+		pushovertest.Response{Status: http.StatusInternalServerError, Body: `{"status":0}`},
+		pushovertest.Response{Status: http.StatusBadRequest, Body: `{"status":0,"user":"invalid","errors":["user identifier is invalid"]}`},
+	)
+
+	c := srv.Client()
+
+	err := c.Push("hello")
+	if !errors.Is(err, pushover.ErrServer) {
+
+		t.Fatalf("got %v, want ErrServer", err)
+	}
+
+	err = c.Push("hello")
+	if !errors.Is(err, pushover.ErrInvalidUser) {
+
+		t.Fatalf("got %v, want ErrInvalidUser", err)
+	}
+
+	err = c.Push("hello")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if n := len(srv.Pushed()); n != 1 {
+
+		t.Fatalf("server received %d messages, want 1", n)
+	}
+}
+
+func TestPushChecks(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	srv.AppLimit = 1
+
+	c := srv.Client()
+
+	err := c.PushMessage(pushover.PushMessage{Message: "hello", Device: "phone"}, false)
+	if !errors.Is(err, pushover.ErrInvalidDevice) {
+
+		t.Fatalf("got %v, want ErrInvalidDevice", err)
+	}
+
+	c.UserKey = "uQiRzpo4DXghDmr9QzzfQu27cmVRsX"
+	err = c.Push("hello")
+	if !errors.Is(err, pushover.ErrInvalidUser) {
+
+		t.Fatalf("got %v, want ErrInvalidUser", err)
+	}
+
+	c.UserKey = srv.UserKey
+	c.AppToken = "azGDORePK8gMaC0QOYAMyEEuzJnyUX"
+	err = c.Push("hello")
+	if !errors.Is(err, pushover.ErrInvalidToken) {
+
+		t.Fatalf("got %v, want ErrInvalidToken", err)
+	}
+
+	c.AppToken = srv.AppToken
+	err = c.Push("hello")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if c.Limits.Remaining != 0 {
+
+		t.Fatalf("remaining is %d, want 0", c.Limits.Remaining)
+	}
+
+	err = c.Push("hello")
+	if !errors.Is(err, pushover.ErrRateLimited) {
+
+		t.Fatalf("got %v, want ErrRateLimited", err)
+	}
+}
+
+// Loopback delivers pushes back to the device under the pushing app
+func TestLoopback(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	srv.Loopback = true
+	token := "aotherTokenaaaaaaaaaaaaaaaaaaa"
+	aid := srv.AddApp(token, "Other")
+
+	c := srv.Client()
+
+	err := c.PushMessage(pushover.PushMessage{Message: "hello", Title: "Title", Monospace: true, TTL: 60}, false)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	c.AppToken = token
+	err = c.Push("from other")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	r := srv.Client()
+	r.SetSecret(srv.Secret)
+	r.DeviceUUID = srv.DeviceID
+
+	_, err = r.FetchMessages()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	msgs := r.MessagesResponse.Messages
+	if len(msgs) != 2 {
+
+		t.Fatalf("fetched %d messages, want 2", len(msgs))
+	}
+
+	m := msgs[0]
+	if m.Message != "hello" || m.Title != "Title" || m.Monospace != 1 || m.TTL != 60 || m.App != srv.AppName || m.Aid != srv.AppID {
+
+		t.Fatalf("got %+v", m)
+	}
+
+	m = msgs[1]
+	if m.Message != "from other" || m.App != "Other" || m.Aid != aid {
+
+		t.Fatalf("got %+v", m)
+	}
+}
+
+func TestReceipts(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+
+	resp, err := c.PushMessageContext(context.Background(), pushover.PushMessage{Message: "help", Priority: pushover.HighestPriority, Expire: 60, Retry: 30}, false)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if len(resp.Receipt) < 1 {
+
+		t.Fatal("emergency push returned no receipt")
+	}
+
+	c.SetSecret(srv.Secret)
+	err = c.Acknowledge(resp.Receipt)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	err = c.GetReceipt(resp.Receipt)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if c.ReceiptResponse.Acknowledged != 1 {
+
+		t.Fatalf("got %+v", c.ReceiptResponse)
+	}
+}
@@ -0,0 +1,134 @@
+package pushover
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry policy for the calls of a Client. Safe and idempotent calls are
+// retried on any transient failure. Other calls, such as PushMessage and
+// RegisterDevice, are only retried when the request was never sent or the
+// API turned it away with a 429 or 5xx, as Pushover asks clients to do.
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts per call including the first
+	MinBackoff  time.Duration // Delay before the first retry
+	MaxBackoff  time.Duration // Longest delay between attempts. A longer Retry-After gives up instead.
+	Jitter      float64       // Fraction of each delay that is randomised, from 0 to 1
+}
+
+// Pushover asks for at least 5 seconds between retries
+var DefaultRetryPolicy = RetryPolicy{
+
+	MaxAttempts: 4,
+	MinBackoff:  5 * time.Second,
+	MaxBackoff:  time.Minute,
+	Jitter:      0.2,
+}
+
+// Calls other than GET requests that can safely be repeated
+var idempotentOps = map[error]bool{
+
+	ErrMarkRead:     true,
+	ErrAcknowledge:  true,
+	ErrValidateUser: true,
+
+	ErrEnableGroupUser:  true,
+	ErrDisableGroupUser: true,
+	ErrRenameGroup:      true,
+
+	ErrRenameDevice:  true,
+	ErrDisableDevice: true,
+}
+
+// Return how long to wait before retrying after the given failed attempt,
+// or false if the call should not be retried
+func (p *RetryPolicy) next(attempt int, err error, idempotent bool) (wait time.Duration, ok bool) {
+
+	if attempt >= p.MaxAttempts {
+
+		return 0, false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+
+		if !apiErr.Temporary() {
+
+			return 0, false
+		}
+
+		// A 429 means the monthly limit is used up, retrying only helps
+		// when the API says when to come back
+		if apiErr.StatusCode == http.StatusTooManyRequests && apiErr.RetryAfter <= 0 {
+
+			return 0, false
+		}
+	} else if !idempotent && !notSent(err) {
+
+		return 0, false
+	}
+
+	wait = p.MinBackoff << uint(attempt-1)
+	if wait > p.MaxBackoff || wait <= 0 {
+
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+
+		wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+	}
+
+	if apiErr != nil && apiErr.RetryAfter > wait {
+
+		if apiErr.RetryAfter > p.MaxBackoff {
+
+			return 0, false
+		}
+		wait = apiErr.RetryAfter
+	}
+
+	return wait, true
+}
+
+// Report whether the request failed before anything was sent
+func notSent(err error) bool {
+
+	var dialErr *DialError
+	if errors.As(err, &dialErr) {
+
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+
+		return opErr.Op == "dial"
+	}
+
+	return false
+}
+
+// Parse a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(s string) time.Duration {
+
+	if len(s) < 1 {
+
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(s); err == nil {
+
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(s); err == nil {
+
+		return time.Until(t)
+	}
+
+	return 0
+}
@@ -0,0 +1,207 @@
+package pushover_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+// A push is not idempotent, but one that never left because the proxy was
+// down is safe to send again
+func TestRetryPushAfterDialFailure(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var dials int
+
+	c := srv.Client()
+	c.Retry = &pushover.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	c.Dial = func(network, addr string) (net.Conn, error) {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		dials++
+		if dials == 1 {
+
+			return nil, errors.New("general SOCKS server failure")
+		}
+		return net.Dial(network, addr)
+	}
+
+	err := c.PushMessage(pushover.PushMessage{Message: "hello"}, false)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if n := len(srv.Pushed()); n != 1 {
+
+		t.Fatalf("server received %d messages, want 1", n)
+	}
+}
+
+func TestDialErrorNotRetriedWithoutPolicy(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.Dial = func(network, addr string) (net.Conn, error) {
+
+		return nil, errors.New("general SOCKS server failure")
+	}
+
+	err := c.PushMessage(pushover.PushMessage{Message: "hello"}, false)
+
+	var dialErr *pushover.DialError
+	if !errors.As(err, &dialErr) {
+
+		t.Fatalf("got %v, want a DialError", err)
+	}
+}
+
+func TestRetryServerError(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	srv.Script("/1/messages.json",
+		pushovertest.Response{Status: http.StatusInternalServerError, Body: `{"status":0}`},
+		pushovertest.Response{Status: http.StatusServiceUnavailable, Body: `{"status":0}`},
+	)
+
+	c := srv.Client()
+	c.Retry = &pushover.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	err := c.Push("hello")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if n := len(srv.Pushed()); n != 1 {
+
+		t.Fatalf("server received %d messages, want 1", n)
+	}
+}
+
+// A 429 is only retried when the API says when to come back
+func TestRetryRateLimited(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.Retry = &pushover.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Second}
+
+	srv.Script("/1/messages.json", pushovertest.Response{Status: http.StatusTooManyRequests, Body: `{"status":0}`})
+
+	err := c.Push("hello")
+	if !errors.Is(err, pushover.ErrRateLimited) {
+
+		t.Fatalf("got %v, want ErrRateLimited", err)
+	}
+	if n := len(srv.Pushed()); n != 0 {
+
+		t.Fatalf("429 without Retry-After was retried, server received %d messages", n)
+	}
+
+	srv.Script("/1/messages.json", pushovertest.Response{
+
+		Status: http.StatusTooManyRequests,
+		Header: http.Header{"Retry-After": {"1"}},
+		Body:   `{"status":0}`,
+	})
+
+	start := time.Now()
+	err = c.Push("hello")
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if time.Since(start) < time.Second {
+
+		t.Fatalf("retried after %v, before Retry-After", time.Since(start))
+	}
+	if n := len(srv.Pushed()); n != 1 {
+
+		t.Fatalf("server received %d messages, want 1", n)
+	}
+}
+
+// Fails every request after it reached the server, as when the connection
+// drops before the response arrives
+type lostResponse struct {
+	mu    sync.Mutex
+	sent  int
+	fails int
+}
+
+func (l *lostResponse) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sent++
+	if l.fails > 0 {
+
+		l.fails--
+		resp.Body.Close()
+		return nil, errors.New("connection reset by peer")
+	}
+
+	return resp, nil
+}
+
+func TestRetryNotResent(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	lost := &lostResponse{fails: 1}
+
+	c := srv.Client()
+	c.Transport = lost
+	c.Retry = &pushover.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	// A push that reached the server may have been delivered
+	err := c.Push("hello")
+	if err == nil {
+
+		t.Fatal("push with a lost response succeeded")
+	}
+	if lost.sent != 1 || len(srv.Pushed()) != 1 {
+
+		t.Fatalf("push sent %d times, server received %d", lost.sent, len(srv.Pushed()))
+	}
+
+	// Marking read can be repeated
+	lost.fails, lost.sent = 1, 0
+	c.SetSecret(srv.Secret)
+	c.DeviceUUID = srv.DeviceID
+
+	err = c.MarkRead(1)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if lost.sent != 2 {
+
+		t.Fatalf("mark read sent %d times, want 2", lost.sent)
+	}
+}
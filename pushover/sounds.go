@@ -0,0 +1,149 @@
+package pushover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// How long the list returned by ListSounds is reused before asking again
+const (
+	SoundsTTL = 24 * time.Hour
+)
+
+// Errors
+var (
+	ErrListSounds  = errors.New("Unable to list sounds")
+	ErrVerifySound = errors.New("Sound is not one of the sounds of the application")
+)
+
+// Sounds built into Pushover, with their descriptions. ListSounds also
+// returns new and custom uploaded sounds and should be preferred.
+var DefaultSounds = map[string]string{
+
+	PushoverSound:     "Pushover (default)",
+	BikeSound:         "Bike",
+	BugleSound:        "Bugle",
+	CashregisterSound: "Cash Register",
+	ClassicalSound:    "Classical",
+	CosmicSound:       "Cosmic",
+	FallingSound:      "Falling",
+	GamelanSound:      "Gamelan",
+	IncomingSound:     "Incoming",
+	IntermissionSound: "Intermission",
+	MagicSound:        "Magic",
+	MechanicalSound:   "Mechanical",
+	PianobarSound:     "Piano Bar",
+	SirenSound:        "Siren",
+	SpacealarmSound:   "Space Alarm",
+	TugboatSound:      "Tug Boat",
+	AlienSound:        "Alien Alarm (long)",
+	ClimbSound:        "Climb (long)",
+	PersistentSound:   "Persistent (long)",
+	EchoSound:         "Pushover Echo (long)",
+	UpdownSound:       "Up Down (long)",
+	NoneSound:         "None (silent)",
+}
+
+var soundName = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+type SoundsResponse struct {
+	Sounds map[string]string `json:"sounds"`
+
+	Status  int    `json:"status"`
+	Request string `json:"request"`
+}
+
+// Return the sounds available to the application, keyed by name with a
+// description as the value. The list is fetched from sounds.json and
+// cached for SoundsTTL.
+func (c *Client) ListSounds() (map[string]string, error) {
+
+	return c.ListSoundsContext(context.Background())
+}
+
+// ListSoundsContext is like ListSounds but uses ctx for cancellation and deadlines
+func (c *Client) ListSoundsContext(ctx context.Context) (sounds map[string]string, err error) {
+
+	if sounds, ok := c.cachedSounds(); ok {
+
+		return sounds, nil
+	}
+
+	err = VerifyAppToken(c.AppToken)
+	if err != nil {
+
+		return
+	}
+
+	var resp SoundsResponse
+	urlF := fmt.Sprintf("%s/sounds.json?token=%s", c.baseUrl(), c.AppToken)
+	_, err = c.doJSON(ctx, "GET", urlF, nil, ErrListSounds, &resp)
+	if err != nil {
+
+		return
+	}
+
+	c.mu.Lock()
+	c.sounds = resp.Sounds
+	c.soundsAt = time.Now()
+	c.mu.Unlock()
+
+	return copySounds(resp.Sounds), nil
+}
+
+// Return a copy of the cached sounds if they are still fresh
+func (c *Client) cachedSounds() (sounds map[string]string, ok bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sounds == nil || time.Since(c.soundsAt) > SoundsTTL {
+
+		return nil, false
+	}
+
+	return copySounds(c.sounds), true
+}
+
+func copySounds(sounds map[string]string) map[string]string {
+
+	m := make(map[string]string, len(sounds))
+	for k, v := range sounds {
+
+		m[k] = v
+	}
+
+	return m
+}
+
+// Check a sound against the sounds of the application. When the list
+// cannot be fetched the sound is let through, as the API falls back to the
+// default sound rather than rejecting the message.
+func (c *Client) verifySound(ctx context.Context, sound string) (err error) {
+
+	if len(sound) < 1 {
+
+		return
+	}
+
+	if !soundName.MatchString(sound) {
+
+		return ErrVerifySound
+	}
+
+	sounds, err := c.ListSoundsContext(ctx)
+	if err != nil {
+
+		return nil
+	}
+
+	if _, ok := sounds[sound]; !ok {
+
+		return ErrVerifySound
+	}
+
+	return
+}
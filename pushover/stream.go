@@ -0,0 +1,270 @@
+package pushover
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+)
+
+// Default streaming endpoint. It can be overridden per Client.
+const (
+	StreamUrl = "wss://client.pushover.net/push"
+)
+
+// How long to wait for any frame before treating the stream as dead. The
+// server sends a keep-alive frame well within this window.
+const (
+	StreamTimeout = 2 * time.Minute
+)
+
+// Frames sent by the server over the stream. Each frame is a single byte.
+const (
+	FrameKeepAlive = '#' // Keep-alive, nothing to do
+	FrameMessage   = '!' // New messages are waiting and should be fetched
+	FrameReload    = 'R' // Reconnect the stream
+	FrameError     = 'E' // Permanent error, login again before reconnecting
+	FrameClosed    = 'A' // Session closed because the device logged in elsewhere
+)
+
+// Errors
+var (
+	ErrStreamError  = errors.New("Stream reported an error, login again before reconnecting")
+	ErrStreamClosed = errors.New("Stream session closed by another login")
+	ErrStreamFrame  = errors.New("Unknown stream frame")
+)
+
+// An open connection to the Open Client stream
+type Stream struct {
+	conn     *websocket.Conn
+	pending  []byte
+	deadline time.Time // From the ctx of OpenStreamContext, zero if none
+}
+
+func (c *Client) streamUrl() string {
+
+	if len(c.StreamUrl) > 0 {
+
+		return c.StreamUrl
+	}
+
+	return StreamUrl
+}
+
+// Open the stream and login with the device id and secret. Dialing goes
+// through the Dial hook so proxies apply to the stream as well.
+func (c *Client) OpenStream() (s *Stream, err error) {
+
+	return c.OpenStreamContext(context.Background())
+}
+
+// OpenStreamContext is like OpenStream but uses ctx while connecting. The
+// deadline of ctx also bounds the lifetime of the stream.
+func (c *Client) OpenStreamContext(ctx context.Context) (s *Stream, err error) {
+
+	secret, device := c.session()
+	if len(secret) < 1 {
+
+		return nil, ErrDeviceAuth
+	}
+
+	urlF := c.streamUrl()
+	u, err := url.Parse(urlF)
+	if err != nil {
+
+		return nil, &PushRespErr{Query: urlF, Err: err}
+	}
+
+	addr := u.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+
+		if u.Scheme == "wss" {
+
+			addr = net.JoinHostPort(addr, "443")
+		} else {
+
+			addr = net.JoinHostPort(addr, "80")
+		}
+	}
+
+	conn, err := dialContext(ctx, c.Dial, "tcp", addr)
+	if err != nil {
+
+		return nil, &PushRespErr{Query: urlF, Err: err}
+	}
+
+	// The connection is not pooled, so the deadline can bound its lifetime
+	deadline, _ := ctx.Deadline()
+	if !deadline.IsZero() {
+
+		conn.SetDeadline(deadline)
+	}
+
+	if u.Scheme == "wss" {
+
+		config := &tls.Config{}
+		if c.TLSConfig != nil {
+
+			config = c.TLSConfig.Clone()
+		}
+		if len(config.ServerName) < 1 {
+
+			config.ServerName = u.Hostname()
+		}
+
+		tlsConn := tls.Client(conn, config)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+
+			conn.Close()
+			return nil, &PushRespErr{Query: urlF, Err: err}
+		}
+		conn = tlsConn
+	}
+
+	config, err := websocket.NewConfig(urlF, c.clientUrl())
+	if err != nil {
+
+		conn.Close()
+		return nil, &PushRespErr{Query: urlF, Err: err}
+	}
+
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+
+		conn.Close()
+		return nil, &PushRespErr{Query: urlF, Err: err}
+	}
+
+	login := fmt.Sprintf("login:%s:%s\n", device, secret)
+	err = websocket.Message.Send(ws, login)
+	if err != nil {
+
+		ws.Close()
+		return nil, &PushRespErr{Query: urlF, Err: err}
+	}
+
+	return &Stream{conn: ws, deadline: deadline}, nil
+}
+
+// Block until the next frame arrives, for at most StreamTimeout and never
+// past the deadline the stream was opened with. Error and session closed
+// frames are returned along with ErrStreamError and ErrStreamClosed.
+func (s *Stream) Next() (frame byte, err error) {
+
+	for len(s.pending) < 1 {
+
+		deadline := time.Now().Add(StreamTimeout)
+		if !s.deadline.IsZero() && s.deadline.Before(deadline) {
+
+			deadline = s.deadline
+		}
+
+		err = s.conn.SetReadDeadline(deadline)
+		if err != nil {
+
+			return
+		}
+
+		var data []byte
+		err = websocket.Message.Receive(s.conn, &data)
+		if err != nil {
+
+			return
+		}
+		s.pending = []byte(strings.TrimSpace(string(data)))
+	}
+
+	frame = s.pending[0]
+	s.pending = s.pending[1:]
+
+	switch frame {
+
+	case FrameKeepAlive, FrameMessage, FrameReload:
+		return
+	case FrameError:
+		return frame, ErrStreamError
+	case FrameClosed:
+		return frame, ErrStreamClosed
+	}
+
+	return frame, ErrStreamFrame
+}
+
+func (s *Stream) Close() error {
+
+	return s.conn.Close()
+}
+
+// Listen on the stream and call onMessage every time new messages are
+// waiting. Reload frames are handled by reconnecting. Listen only returns
+// on error, with ErrStreamError when the device must login again and
+// ErrStreamClosed when another session has taken over.
+func (c *Client) Listen(onMessage func()) (err error) {
+
+	return c.ListenContext(context.Background(), onMessage)
+}
+
+// ListenContext is like Listen but closes the stream and returns ctx.Err()
+// once ctx is done
+func (c *Client) ListenContext(ctx context.Context, onMessage func()) (err error) {
+
+	for {
+
+		s, err := c.OpenStreamContext(ctx)
+		if err != nil {
+
+			return err
+		}
+
+		stop := make(chan struct{})
+		go func() {
+
+			select {
+
+			case <-ctx.Done():
+				s.Close()
+			case <-stop:
+			}
+		}()
+
+		err = listen(s, onMessage)
+		close(stop)
+		s.Close()
+		if ctx.Err() != nil {
+
+			return ctx.Err()
+		}
+		if err != nil {
+
+			return err
+		}
+	}
+}
+
+// Read frames until the stream ends. Returns nil when asked to reconnect.
+func listen(s *Stream, onMessage func()) (err error) {
+
+	for {
+
+		frame, err := s.Next()
+		if err != nil {
+
+			return err
+		}
+
+		switch frame {
+
+		case FrameMessage:
+			onMessage()
+		case FrameReload:
+			return nil
+		}
+	}
+}
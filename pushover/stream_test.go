@@ -0,0 +1,237 @@
+package pushover_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+// Return a client logged in to the fake server with its device
+func loggedIn(t *testing.T, srv *pushovertest.Server) *pushover.Client {
+
+	t.Helper()
+
+	c := srv.Client()
+	c.UserName = srv.Email
+	c.UserPassword = srv.Password
+	c.DeviceUUID = srv.DeviceID
+
+	err := c.LoginDevice()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// Wait until the server has seen n stream logins
+func waitLogins(t *testing.T, srv *pushovertest.Server, n int) {
+
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.StreamLogins() < n {
+
+		if time.Now().After(deadline) {
+
+			t.Fatalf("waited for %d stream logins, got %d", n, srv.StreamLogins())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Listen in the background. Every message frame is reported on the first
+// channel and the result of Listen on the second.
+func listen(ctx context.Context, c *pushover.Client) (<-chan struct{}, <-chan error) {
+
+	messages := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+
+		done <- c.ListenContext(ctx, func() { messages <- struct{}{} })
+	}()
+
+	return messages, done
+}
+
+func TestStreamFrames(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := loggedIn(t, srv)
+	messages, done := listen(context.Background(), c)
+	waitLogins(t, srv, 1)
+
+	// Keep-alives are ignored, new messages are reported
+	srv.Notify(pushover.FrameKeepAlive)
+	srv.Notify(pushover.FrameMessage)
+	select {
+
+	case <-messages:
+	case err := <-done:
+		t.Fatalf("Listen returned %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message reported")
+	}
+	if len(messages) > 0 {
+
+		t.Fatal("keep-alive was reported as a message")
+	}
+
+	// Reload reconnects and keeps listening
+	srv.Notify(pushover.FrameReload)
+	waitLogins(t, srv, 2)
+
+	srv.Notify(pushover.FrameMessage)
+	select {
+
+	case <-messages:
+	case err := <-done:
+		t.Fatalf("Listen returned %v after reload", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message reported after reload")
+	}
+
+	// An error frame ends Listen so the caller can login again
+	srv.Notify(pushover.FrameError)
+	select {
+
+	case err := <-done:
+		if err != pushover.ErrStreamError {
+
+			t.Fatalf("got %v, want ErrStreamError", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen did not return on an error frame")
+	}
+}
+
+func TestStreamClosed(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := loggedIn(t, srv)
+	_, done := listen(context.Background(), c)
+	waitLogins(t, srv, 1)
+
+	srv.Notify(pushover.FrameClosed)
+	select {
+
+	case err := <-done:
+		if err != pushover.ErrStreamClosed {
+
+			t.Fatalf("got %v, want ErrStreamClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen did not return on a session closed frame")
+	}
+
+	// Another session has taken over, so the stream must not reconnect
+	time.Sleep(50 * time.Millisecond)
+	if n := srv.StreamLogins(); n != 1 {
+
+		t.Fatalf("stream logged in %d times after being closed", n)
+	}
+}
+
+func TestStreamBadLogin(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := loggedIn(t, srv)
+	c.Login.Secret = "wrong"
+
+	err := c.Listen(func() {})
+	if err != pushover.ErrStreamError {
+
+		t.Fatalf("got %v, want ErrStreamError", err)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := loggedIn(t, srv)
+	_, done := listen(ctx, c)
+	waitLogins(t, srv, 1)
+
+	cancel()
+	select {
+
+	case err := <-done:
+		if err != context.Canceled {
+
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen did not return once cancelled")
+	}
+}
+
+// Keep-alives do not extend a stream past the deadline it was opened with
+func TestStreamDeadline(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	c := loggedIn(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	s, err := c.OpenStreamContext(ctx)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	defer s.Close()
+	waitLogins(t, srv, 1)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+
+		for {
+
+			select {
+
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				srv.Notify(pushover.FrameKeepAlive)
+			}
+		}
+	}()
+
+	start := time.Now()
+	for {
+
+		_, err = s.Next()
+		if err != nil {
+
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+
+			t.Fatal("stream outlived its deadline")
+		}
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+
+		t.Fatalf("got %v, want a timeout", err)
+	}
+}
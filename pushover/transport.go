@@ -0,0 +1,127 @@
+package pushover
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Defaults for the transport shared by the requests of a Client. The
+// timeouts are generous since requests may go through Tor.
+const (
+	MaxIdleConns        = 10
+	MaxIdleConnsPerHost = 4
+	IdleConnTimeout     = 90 * time.Second
+	TLSHandshakeTimeout = 30 * time.Second
+	ResponseTimeout     = 60 * time.Second
+	RequestTimeout      = 2 * time.Minute // Whole request, for clients made by NewHTTPClient
+)
+
+// A connection that could not be made, directly or through the Dial hook
+// such as a SOCKS proxy. Nothing was sent, so any call can be retried.
+type DialError struct {
+	Err error
+}
+
+func (e *DialError) Error() string {
+
+	return "dial: " + e.Err.Error()
+}
+
+func (e *DialError) Unwrap() error {
+
+	return e.Err
+}
+
+// Create a transport that reuses connections and dials through dial, or
+// directly when dial is nil. One transport can be shared by every Client
+// using the same proxy.
+func NewTransport(dial func(network, addr string) (net.Conn, error), config *tls.Config) *http.Transport {
+
+	return &http.Transport{
+
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+
+			return dialContext(ctx, dial, network, addr)
+		},
+		TLSClientConfig:       config,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          MaxIdleConns,
+		MaxIdleConnsPerHost:   MaxIdleConnsPerHost,
+		IdleConnTimeout:       IdleConnTimeout,
+		TLSHandshakeTimeout:   TLSHandshakeTimeout,
+		ResponseHeaderTimeout: ResponseTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// Create an http client on a new transport, with RequestTimeout as its time
+// limit. It can be shared as the HTTPClient of every Client using the same
+// proxy.
+func NewHTTPClient(dial func(network, addr string) (net.Conn, error), config *tls.Config) *http.Client {
+
+	return &http.Client{Transport: NewTransport(dial, config), Timeout: RequestTimeout}
+}
+
+// Return the http client used for API requests. Unless one was supplied it
+// is created on first use and kept, so connections are reused across calls.
+func (c *Client) httpClient() *http.Client {
+
+	if c.HTTPClient != nil {
+
+		return c.HTTPClient
+	}
+
+	c.once.Do(func() {
+
+		rt := c.Transport
+		if rt == nil {
+
+			rt = NewTransport(c.Dial, c.TLSConfig)
+		}
+
+		c.transport = &http.Client{Transport: rt, Timeout: c.Timeout}
+	})
+
+	return c.transport
+}
+
+// Dial through dial within the lifetime of ctx. The hook takes no context,
+// so the dial is abandoned rather than interrupted when ctx is done.
+func dialContext(ctx context.Context, dial func(network, addr string) (net.Conn, error), network, addr string) (net.Conn, error) {
+
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+
+	ch := make(chan dialed, 1)
+	go func() {
+
+		conn, err := dialWith(dial, network, addr)
+		ch <- dialed{conn, err}
+	}()
+
+	select {
+
+	case d := <-ch:
+		if d.err != nil {
+
+			return nil, &DialError{Err: d.err}
+		}
+		return d.conn, nil
+
+	case <-ctx.Done():
+		// Close the connection if the dial completes after all
+		go func() {
+
+			if d := <-ch; d.conn != nil {
+
+				d.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
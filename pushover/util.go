@@ -21,6 +21,11 @@ const (
 	DeviceUUIDLimit = 36
 
 	ReceiptLimit = 30
+
+	AttachmentLimit = 2621440 // 2.5MB
+
+	GroupNameLimit = 100
+	GroupMemoLimit = 200
 )
 
 // Errors
@@ -28,13 +33,19 @@ var (
 	ErrVerifyDeviceName = errors.New("DeviceName must contain at least one character and may only contain letters, numbers, dashes, and underscores")
 	ErrVerifyUserKey    = fmt.Errorf("User and group identifiers must be at least %d characters long, case-sensitive, and may only contain letters and numbers\n", UserKeyLimit)
 	ErrVerifyAppToken   = fmt.Errorf("Application tokens are case-sensitive and must be at least %d characters long, and may only contain letters and numbers\n", AppTokenLimit)
-	ErrVerifyReceipt    = fmt.Errorf("Receipt must be at least %s and is case-sensitive", ReceiptLimit)
+	ErrVerifyReceipt    = fmt.Errorf("Receipt must be at least %d and is case-sensitive", ReceiptLimit)
 
 	ErrMsgLimit   = fmt.Errorf("Message specified is not specified or is over the %d char limit\n", MessageLimit)
 	ErrTitleLimit = fmt.Errorf("Title specified is over the %d char limit\n", MessageTitleLimit)
 	ErrUrlTLimit  = fmt.Errorf("Url Title specified is over the %d char limit\n", UrlTitleLimit)
 	ErrUrlLimit   = fmt.Errorf("The url is over the %d char limit\n", UrlLimit)
 	ErrPriority   = errors.New("A priority higher than 1 needs an expiry parm")
+	ErrFormat     = errors.New("Html and Monospace can not be used together")
+	ErrTTL        = errors.New("TTL can not be negative")
+
+	ErrAttachment      = errors.New("Attachment has no data")
+	ErrAttachmentLimit = fmt.Errorf("Attachment is over the %d byte limit", AttachmentLimit)
+	ErrAttachmentType  = errors.New("Attachment must be an image")
 )
 
 func btos(b bool) string {
@@ -137,6 +148,25 @@ func VerifyPushMessage(msg PushMessage) (err error) {
 
 		return ErrPriority
 	}
+	if msg.Html && msg.Monospace {
+
+		return ErrFormat
+	}
+	if msg.TTL < 0 {
+
+		return ErrTTL
+	}
+	if msg.Attachment != nil {
+
+		if msg.Attachment.Reader == nil {
+
+			return ErrAttachment
+		}
+		if n, ok := msg.Attachment.size(); ok && n > AttachmentLimit {
+
+			return ErrAttachmentLimit
+		}
+	}
 
 	return
 }
@@ -0,0 +1,96 @@
+package pushover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Errors
+var (
+	ErrValidateUser = errors.New("Unable to validate user")
+)
+
+type ValidateResponse struct {
+	Group    int      `json:"group"`
+	Devices  []string `json:"devices"`
+	Licenses []string `json:"licenses"`
+
+	Status  int      `json:"status"`
+	Request string   `json:"request"`
+	Errors  []string `json:"errors"`
+}
+
+// Result of validating a user or group key
+type Validation struct {
+	Valid    bool     // The key exists and, if given, the device belongs to it
+	Group    bool     // The key is a delivery group
+	Devices  []string // Active device names of the user
+	Licenses []string // Platforms the user is licensed for
+	Errors   []string // Why the key or device was rejected
+}
+
+// Check a user or group key, and optionally one of its device names,
+// against users/validate.json
+func (c *Client) ValidateUser(user, device string) (Validation, error) {
+
+	return c.ValidateUserContext(context.Background(), user, device)
+}
+
+// ValidateUserContext is like ValidateUser but uses ctx for cancellation and
+// deadlines. A key or device the API rejects is reported with Valid false
+// rather than an error.
+func (c *Client) ValidateUserContext(ctx context.Context, user, device string) (v Validation, err error) {
+
+	err = VerifyAppToken(c.AppToken)
+	if err != nil {
+
+		return
+	}
+
+	err = VerifyUserKey(user)
+	if err != nil {
+
+		return
+	}
+
+	err = VerifyDeviceName(device)
+	if err != nil {
+
+		return
+	}
+
+	vars := url.Values{}
+	vars.Add("token", c.AppToken)
+	vars.Add("user", user)
+	if len(device) > 0 {
+
+		vars.Add("device", device)
+	}
+
+	var resp ValidateResponse
+	urlF := fmt.Sprintf("%s%s", c.baseUrl(), "/users/validate.json")
+	_, err = c.doJSON(ctx, "POST", urlF, vars, ErrValidateUser, &resp)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (errors.Is(apiErr, ErrInvalidUser) || errors.Is(apiErr, ErrInvalidDevice)) {
+
+		v.Errors = apiErr.Errors
+		return v, nil
+	}
+	if err != nil {
+
+		return
+	}
+
+	v = Validation{
+
+		Valid:    true,
+		Group:    resp.Group == 1,
+		Devices:  resp.Devices,
+		Licenses: resp.Licenses,
+	}
+
+	return
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/notification"
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+// Policies for registering a new device when the current one is deleted
+const (
+	ReregisterAuto  = "auto"  // Register a new device and carry on
+	ReregisterNever = "never" // Stop the account until the config is fixed
+)
+
+// Some errors
+var (
+	ErrDeviceGone = errors.New("Device was deleted and Reregister is set to never")
+)
+
+// Where an account is in its lifecycle. Each state is reported in the log
+// and in the state file as the account moves through it.
+type SessionState int
+
+const (
+	SessionLogin    SessionState = iota // Resuming the session or logging in
+	SessionRegister                     // Registering a device
+	SessionRunning                      // Receiving messages
+	SessionFailed                       // Stopped by an error that needs the config fixed
+	SessionStopped                      // Shut down
+)
+
+func (st SessionState) String() string {
+
+	switch st {
+
+	case SessionLogin:
+		return "logging in"
+	case SessionRegister:
+		return "registering"
+	case SessionRunning:
+		return "running"
+	case SessionFailed:
+		return "failed"
+	case SessionStopped:
+		return "stopped"
+	}
+
+	return "unknown"
+}
+
+// State of a running account
+type Session struct {
+	cfg     *ClientConfig
+	acn     *Account
+	client  *pushover.Client
+	history *History
+	breaker *Breaker
+
+	mu      sync.Mutex
+	state   SessionState
+	expired bool               // Saved secret was rejected, login with the password
+	stop    context.CancelFunc // Stops receiving when the state changes
+}
+
+func NewSession(cfg *ClientConfig, acn *Account, client *pushover.Client) *Session {
+
+	return &Session{
+
+		cfg:     cfg,
+		acn:     acn,
+		client:  client,
+		breaker: NewBreaker(),
+		state:   SessionStopped,
+	}
+}
+
+// Return the history of the current device
+func (s *Session) History() *History {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.history
+}
+
+func (s *Session) State() SessionState {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state
+}
+
+// Move to a new state, stop receiving if leaving SessionRunning and report
+// the change
+func (s *Session) setState(state SessionState) {
+
+	s.mu.Lock()
+	prev := s.state
+	s.state = state
+	if prev == SessionRunning && state != SessionRunning && s.stop != nil {
+
+		s.stop()
+	}
+	s.mu.Unlock()
+
+	if prev == state {
+
+		return
+	}
+
+	log.Infof("%s: %s", s.acn.Username, state)
+	s.cfg.state.SetStatus(s.acn.Username, state.String())
+	err := s.cfg.state.Flush()
+	if err != nil {
+
+		log.Warn(err)
+	}
+}
+
+// Run the account until ctx is done or it fails
+func (s *Session) Run(ctx context.Context) {
+
+	// Sounds are shared by every account so only fetch them once. This uses
+	// the ctx of Run, as receiving is cancelled on every state change.
+	go s.cfg.prefetch.Do(func() { s.cfg.PrefetchSounds(ctx, s.client) })
+
+	s.setState(SessionLogin)
+
+	for ctx.Err() == nil {
+
+		var err error
+		switch s.State() {
+
+		case SessionLogin:
+			err = s.login(ctx)
+		case SessionRegister:
+			err = s.register(ctx)
+		case SessionRunning:
+			err = s.receive(ctx)
+		default:
+			return
+		}
+
+		if err == nil || ctx.Err() != nil {
+
+			continue
+		}
+		if permanent(err) {
+
+			log.Errorf("%s: %s", s.acn.Username, err)
+			s.setState(SessionFailed)
+			return
+		}
+
+		// Try the same step again once the API recovers
+		log.Warnf("%s: %s", s.acn.Username, err)
+		s.record(err)
+		wait := s.breaker.Wait()
+		if wait < 1 {
+
+			wait = time.Duration(s.cfg.Globals.CheckSeconds) * time.Second
+		}
+		sleep(ctx, wait)
+	}
+
+	s.setState(SessionStopped)
+}
+
+// Report whether retrying cannot help until the config is changed
+func permanent(err error) bool {
+
+	switch {
+
+	case errors.Is(err, pushover.ErrUserName),
+		errors.Is(err, pushover.ErrUserPassword),
+		errors.Is(err, pushover.ErrVerifyDeviceName),
+		errors.Is(err, pushover.ErrStreamClosed),
+		errors.Is(err, ErrNoTwoFactor),
+		errors.Is(err, ErrDeviceGone):
+		return true
+	}
+
+	var apiErr *pushover.APIError
+	if errors.As(err, &apiErr) {
+
+		return !apiErr.Temporary()
+	}
+
+	return false
+}
+
+// Move to the state that recovers from an authentication failure. Returns
+// false if err is not one.
+func (s *Session) check(err error) bool {
+
+	switch {
+
+	case errors.Is(err, pushover.ErrSecretExpired):
+		log.Warnf("%s: Secret is no longer valid", s.acn.Username)
+		s.relogin()
+		return true
+
+	case errors.Is(err, pushover.ErrInvalidDevice):
+		log.Warnf("%s: Device %s no longer exists", s.acn.Username, s.cfg.DeviceUUID(s.acn))
+		s.setState(SessionRegister)
+		return true
+	}
+
+	return false
+}
+
+// Login again with the password, as the saved secret was rejected
+func (s *Session) relogin() {
+
+	s.mu.Lock()
+	s.expired = true
+	s.mu.Unlock()
+
+	s.setState(SessionLogin)
+}
+
+// Resume with the saved secret, or login with the password when there is
+// none or it has expired
+func (s *Session) login(ctx context.Context) (err error) {
+
+	s.mu.Lock()
+	expired := s.expired
+	s.mu.Unlock()
+
+	if expired {
+
+		err = s.cfg.Login(ctx, s.client, s.acn)
+	} else {
+
+		err = s.cfg.Authenticate(ctx, s.client, s.acn)
+	}
+	if err != nil {
+
+		return
+	}
+
+	s.mu.Lock()
+	s.expired = false
+	s.mu.Unlock()
+
+	if len(s.cfg.DeviceUUID(s.acn)) < 1 {
+
+		s.setState(SessionRegister)
+		return
+	}
+
+	s.setState(SessionRunning)
+	return
+}
+
+// Register a device and save its UUID to the config. A device that was
+// deleted is only replaced when the Reregister policy allows it.
+func (s *Session) register(ctx context.Context) (err error) {
+
+	if len(s.cfg.DeviceUUID(s.acn)) > 0 && s.acn.Reregister == ReregisterNever {
+
+		return ErrDeviceGone
+	}
+
+	_, err = s.client.RegisterDeviceContext(ctx)
+	if s.check(err) {
+
+		return nil
+	}
+	if err != nil {
+
+		return
+	}
+
+	err = s.cfg.SetDeviceUUID(s.acn, s.client.DeviceUUID)
+	if err != nil {
+
+		return
+	}
+	log.Infof("%s: Registered device %s", s.acn.Username, s.cfg.DeviceUUID(s.acn))
+
+	s.setState(SessionRunning)
+	return
+}
+
+// Receive messages over the stream, or by polling, until the state changes
+func (s *Session) receive(ctx context.Context) (err error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	s.stop = cancel
+	s.mu.Unlock()
+
+	// Messages are kept per device so a new device starts a new history
+	f := HistoryFile(s.cfg.Globals.CacheDir, s.cfg.DeviceUUID(s.acn))
+	if h := s.History(); h == nil || h.file != f {
+
+		h, err = LoadHistory(f)
+		if err != nil {
+
+			return
+		}
+
+		s.mu.Lock()
+		s.history = h
+		s.mu.Unlock()
+	}
+
+	// Pick up anything that arrived while we were offline
+	s.ProcessMessages(ctx)
+
+	if s.cfg.Globals.Polling {
+
+		s.Poll(ctx)
+		return
+	}
+
+	for ctx.Err() == nil {
+
+		// Hold off while the API keeps failing
+		if wait := s.breaker.Wait(); wait > 0 {
+
+			sleep(ctx, wait)
+			continue
+		}
+
+		err = s.client.ListenContext(ctx, func() { s.ProcessMessages(ctx) })
+		switch {
+
+		case err == context.Canceled:
+			return nil
+
+		case err == pushover.ErrStreamClosed:
+			return
+
+		case err == pushover.ErrStreamError:
+			log.Warnf("Listen: %s", err)
+			s.relogin()
+			return nil
+
+		default:
+			// Fall back to polling until the stream can be reopened
+			log.Warnf("Listen: %s", err)
+			s.record(err)
+
+			if !sleep(ctx, time.Duration(s.cfg.Globals.CheckSeconds)*time.Second) {
+
+				return nil
+			}
+			s.ProcessMessages(ctx)
+		}
+	}
+
+	return nil
+}
+
+// Record the outcome of a call in the circuit breaker and log when it
+// opens or closes
+func (s *Session) record(err error) {
+
+	prev := s.breaker.State()
+	if err != nil {
+
+		s.breaker.Failure()
+	} else {
+
+		s.breaker.Success()
+	}
+
+	state := s.breaker.State()
+	if state == prev {
+
+		return
+	}
+
+	switch state {
+
+	case BreakerOpen:
+		log.Warnf("%s: API keeps failing, pausing for %s", s.acn.Username, s.breaker.Cooldown)
+	case BreakerClosed:
+		log.Infof("%s: API is reachable again", s.acn.Username)
+	}
+}
+
+// Show a notification and close it when its message expires. The message
+// is dropped from the history at the same time.
+func (s *Session) pushUntil(n *notification.Message, expires time.Time) {
+
+	ttl := time.Until(expires)
+	n.ExpireTime = int(ttl / time.Millisecond)
+
+	id, err := n.PushID()
+	if err != nil {
+
+		log.Warn(err)
+		return
+	}
+
+	history := s.History()
+	time.AfterFunc(ttl, func() {
+
+		err := notification.Close(id)
+		if err != nil {
+
+			log.Warn(err)
+		}
+
+		err = history.Flush()
+		if err != nil {
+
+			log.Warn(err)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TheCreeper/OpenPushOver/pushover/pushovertest"
+)
+
+// Run the session until the returned func is called, which waits for it
+// to stop
+func runSession(s *Session) (stop func()) {
+
+	// Skip prefetching sounds, which outlives Run
+	s.cfg.prefetch.Do(func() {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+
+		s.Run(ctx)
+		close(done)
+	}()
+
+	return func() {
+
+		cancel()
+		<-done
+	}
+}
+
+// Wait until ok reports true
+func waitFor(t *testing.T, what string, ok func() bool) {
+
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+
+		if time.Now().After(deadline) {
+
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Login → Running → (secret expired) → Login with the password → Running
+func TestSessionSecretExpired(t *testing.T) {
+
+	srv := pushovertest.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv)
+	acn := &cfg.Accounts[0]
+	acn.DeviceUUID = srv.DeviceID
+	cfg.state.SetSecret(acn.Username, "stalesecret0123456789")
+
+	s := NewSession(cfg, acn, cfg.NewClient(acn))
+	stop := runSession(s)
+
+	waitFor(t, "a new secret", func() bool {
+
+		return s.State() == SessionRunning && cfg.state.Secret(acn.Username) == srv.Secret
+	})
+	if s.client.Secret() != srv.Secret {
+
+		t.Fatalf("client secret is %q", s.client.Secret())
+	}
+
+	stop()
+	if s.State() != SessionStopped {
+
+		t.Fatalf("session is %s after stopping", s.State())
+	}
+}
+
+// Running → (device_id invalid) → Register, under both Reregister policies
+func TestSessionDeviceDeleted(t *testing.T) {
+
+	deleted := "deleteddevice0123456789"
+
+	t.Run(ReregisterAuto, func(t *testing.T) {
+
+		srv := pushovertest.NewServer()
+		defer srv.Close()
+
+		cfg := testConfig(t, srv)
+		acn := &cfg.Accounts[0]
+		acn.DeviceUUID = deleted
+		acn.Reregister = ReregisterAuto
+		cfg.state.SetSecret(acn.Username, srv.Secret)
+
+		s := NewSession(cfg, acn, cfg.NewClient(acn))
+		stop := runSession(s)
+		defer stop()
+
+		waitFor(t, "a new device", func() bool {
+
+			return s.State() == SessionRunning && cfg.DeviceUUID(acn) == srv.DeviceID
+		})
+		if _, ok := srv.Device(srv.DeviceID); !ok {
+
+			t.Fatal("no device was registered")
+		}
+
+		saved, err := GetCFG(ConfigFile)
+		if err != nil {
+
+			t.Fatal(err)
+		}
+		if saved.Accounts[0].DeviceUUID != srv.DeviceID {
+
+			t.Fatalf("saved device id is %q", saved.Accounts[0].DeviceUUID)
+		}
+	})
+
+	t.Run(ReregisterNever, func(t *testing.T) {
+
+		srv := pushovertest.NewServer()
+		defer srv.Close()
+
+		cfg := testConfig(t, srv)
+		acn := &cfg.Accounts[0]
+		acn.DeviceUUID = deleted
+		acn.Reregister = ReregisterNever
+		cfg.state.SetSecret(acn.Username, srv.Secret)
+
+		s := NewSession(cfg, acn, cfg.NewClient(acn))
+		stop := runSession(s)
+		defer stop()
+
+		waitFor(t, "the session to fail", func() bool {
+
+			return s.State() == SessionFailed
+		})
+		if cfg.DeviceUUID(acn) != deleted {
+
+			t.Fatalf("device id changed to %q", cfg.DeviceUUID(acn))
+		}
+		if _, ok := srv.Device(srv.DeviceID); ok {
+
+			t.Fatal("a device was registered")
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/TheCreeper/OpenPushOver/pushover"
+)
+
+func SoundFile(cacheDir, sound string) (string, error) {
+
+	return filepath.Abs(filepath.Join(cacheDir, sound+".wav"))
+}
+
+// Download every sound into the cache directory so notifications do not
+// wait on a fetch. The sounds are listed with the AppToken from the config
+// when one is set, otherwise only the built in sounds are fetched.
+func (cfg *ClientConfig) PrefetchSounds(ctx context.Context, client *pushover.Client) {
+
+	sounds := pushover.DefaultSounds
+	if len(client.AppToken) > 0 {
+
+		list, err := client.ListSoundsContext(ctx)
+		if err != nil {
+
+			log.Warnf("ListSounds: %s", err)
+		} else {
+
+			sounds = list
+		}
+	}
+
+	fetched := 0
+	for name := range sounds {
+
+		if name == pushover.NoneSound {
+
+			continue
+		}
+
+		f, err := SoundFile(cfg.Globals.CacheDir, name)
+		if err != nil {
+
+			log.Warn(err)
+			continue
+		}
+
+		exists, err := CachedFile(f)
+		if err != nil {
+
+			log.Warn(err)
+		}
+		if exists {
+
+			continue
+		}
+
+		b, err := client.FetchSoundContext(ctx, name)
+		if err != nil {
+
+			if ctx.Err() != nil {
+
+				return
+			}
+			log.Warnf("FetchSound: %s", err)
+			continue
+		}
+
+		err = WriteToFile(f, b)
+		if err != nil {
+
+			log.Warn(err)
+			continue
+		}
+		fetched++
+	}
+
+	if fetched > 0 {
+
+		log.Infof("Fetched %d Sounds", fetched)
+	}
+}
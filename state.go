@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Secrets kept out of config.json, in a file only the owner can read
+type State struct {
+	mu   sync.Mutex
+	file string
+
+	Accounts map[string]AccountState // Keyed by account username
+}
+
+type AccountState struct {
+	Secret string // Device secret from the last login
+
+	Status string    `json:",omitempty"` // State of the running session, see SessionState
+	Since  time.Time `json:",omitempty"` // When Status last changed
+}
+
+// The state file lives next to the config file
+func StateFile(configFile string) string {
+
+	return filepath.Join(filepath.Dir(configFile), "state.json")
+}
+
+// Load the state from a file. A missing file gives an empty state.
+func LoadState(f string) (st *State, err error) {
+
+	st = &State{file: f, Accounts: make(map[string]AccountState)}
+
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+
+		return st, nil
+	}
+	if err != nil {
+
+		return
+	}
+
+	err = json.Unmarshal(b, st)
+	if err != nil {
+
+		return
+	}
+	if st.Accounts == nil {
+
+		st.Accounts = make(map[string]AccountState)
+	}
+
+	return
+}
+
+func (st *State) Secret(username string) string {
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.Accounts[username].Secret
+}
+
+// Set or, with an empty secret, forget the secret of an account
+func (st *State) SetSecret(username, secret string) {
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	a := st.Accounts[username]
+	a.Secret = secret
+	st.Accounts[username] = a
+}
+
+func (st *State) SetStatus(username, status string) {
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	a := st.Accounts[username]
+	a.Status = status
+	a.Since = time.Now()
+	st.Accounts[username] = a
+}
+
+// Write the state to its file with owner only permissions. The file is
+// replaced in one step so a crash never leaves it half written.
+func (st *State) Flush() (err error) {
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	b, err := json.MarshalIndent(st, "", "	")
+	if err != nil {
+
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(st.file), ".state-")
+	if err != nil {
+
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	// TempFile already creates the file as 0600
+	_, err = tmp.Write(b)
+	if err != nil {
+
+		tmp.Close()
+		return
+	}
+
+	err = tmp.Close()
+	if err != nil {
+
+		return
+	}
+
+	return os.Rename(tmp.Name(), st.file)
+}
+
+// Print the last reported state of each account
+func (cfg *ClientConfig) PrintStatus() {
+
+	for _, acn := range cfg.Accounts {
+
+		a := cfg.state.Accounts[acn.Username]
+		status := a.Status
+		if len(status) < 1 {
+
+			status = "never started"
+		}
+
+		fmt.Printf("%s\t%s", acn.Username, status)
+		if !a.Since.IsZero() {
+
+			fmt.Printf(" since %s", a.Since.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Println()
+	}
+}
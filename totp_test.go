@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238 appendix B, cut to 6 digits
+func TestTOTP(t *testing.T) {
+
+	// "12345678901234567890" in base32
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, v := range cases {
+
+		code, err := TOTP(secret, time.Unix(v.unix, 0))
+		if err != nil {
+
+			t.Fatal(err)
+		}
+		if code != v.code {
+
+			t.Errorf("at %d: got %s, want %s", v.unix, code, v.code)
+		}
+	}
+
+	// Authenticator apps show secrets in lower case groups, padded or not
+	code, err := TOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+	if err != nil || code != "287082" {
+
+		t.Errorf("got %s, %v for a grouped secret", code, err)
+	}
+
+	_, err = TOTP("not base32!", time.Unix(59, 0))
+	if err == nil {
+
+		t.Error("invalid secret was accepted")
+	}
+}
@@ -8,13 +8,35 @@ import (
 
 func WriteToFile(path string, b []byte) (err error) {
 
-	f, err := os.OpenFile(filepath.Clean(path), os.O_RDWR|os.O_CREATE, 0666)
+	return writeFile(path, b, 0666)
+}
+
+// Write a file only its owner can read, such as the history which holds
+// decrypted message bodies
+func WritePrivateFile(path string, b []byte) (err error) {
+
+	return writeFile(path, b, 0600)
+}
+
+func writeFile(path string, b []byte, perm os.FileMode) (err error) {
+
+	f, err := os.OpenFile(filepath.Clean(path), os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 
 		return
 	}
 	defer f.Close()
 
+	// Files from older versions were created with wider permissions
+	if perm == 0600 {
+
+		err = f.Chmod(perm)
+		if err != nil {
+
+			return
+		}
+	}
+
 	buf := bufio.NewWriter(f)
 	defer buf.Flush()
 
@@ -27,10 +49,27 @@ func WriteToFile(path string, b []byte) (err error) {
 	return
 }
 
+// Report whether a downloaded file is in the cache. Empty files left by
+// failed downloads are treated as missing so they are fetched again.
+func CachedFile(path string) (bool, error) {
+
+	fi, err := os.Stat(path)
+	if err == nil {
+
+		return fi.Size() > 0, nil
+	}
+	if os.IsNotExist(err) {
+
+		return false, nil
+	}
+
+	return false, err
+}
+
 func FileExists(path string) (bool, error) {
 
 	_, err := os.Stat(path)
-	if os.IsExist(err) {
+	if err == nil {
 
 		return true, nil
 	}